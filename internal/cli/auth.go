@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/config"
+	"github.com/momokii/ss-watcher/pkg/gdrive"
+)
+
+func runAuth(args []string) error {
+	if len(args) == 0 || (args[0] != "login" && args[0] != "status" && args[0] != "logout") {
+		return fmt.Errorf("usage: ss-watcher auth login|status|logout [-target name] [-config file]")
+	}
+	action := args[0]
+
+	var common commonFlags
+	var target string
+	var noBrowser bool
+	var timeout time.Duration
+
+	fs := newFlagSet("auth " + action)
+	fs.StringVar(&target, "target", config.DefaultTarget, "google drive target to log in")
+	fs.BoolVar(&noBrowser, "no-browser", false, "only print the login url, do not open the browser")
+	fs.DurationVar(&timeout, "timeout", 5*time.Minute, "how long to wait for the login on the browser")
+	common.bind(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	t, err := cfg.Target(target)
+	if err != nil {
+		return err
+	}
+	if !t.UseOAuth() {
+		return fmt.Errorf("target '%s' does not use oauth, set oauth.client_secret on the config file", target)
+	}
+
+	switch action {
+	case "login":
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		open := openBrowser
+		if noBrowser {
+			open = nil
+		}
+
+		if err := gdrive.Login(ctx, t.GDrive.OAuth.ClientSecret, t.GDrive.OAuth.Token, open); err != nil {
+			return err
+		}
+
+		return printDriveUser(cfg, target)
+
+	case "status":
+		tok, err := gdrive.LoadToken(t.GDrive.OAuth.Token)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Token file   :", t.GDrive.OAuth.Token)
+		fmt.Println("Refresh token:", tok.RefreshToken != "")
+		if !tok.Expiry.IsZero() {
+			fmt.Println("Access expiry:", tok.Expiry.Local().Format(time.RFC1123))
+		}
+
+		return printDriveUser(cfg, target)
+
+	default:
+		if err := gdrive.Logout(context.Background(), t.GDrive.OAuth.Token); err != nil {
+			return err
+		}
+
+		fmt.Println("Logged out, token removed: ", t.GDrive.OAuth.Token)
+		return nil
+	}
+}
+
+// printDriveUser call drive with the saved token, so the login is checked end to end
+func printDriveUser(cfg *config.Config, target string) error {
+	store, err := openTarget(cfg, target, nil)
+	if err != nil {
+		return err
+	}
+
+	about, err := store.(gdrive.GDrive).GetService().About.Get().Fields("user(displayName,emailAddress)").Do()
+	if err != nil {
+		return fmt.Errorf("Error Get Drive User: %v", err)
+	}
+
+	fmt.Printf("Logged in as : %s <%s>\n", about.User.DisplayName, about.User.EmailAddress)
+
+	return nil
+}
+
+// openBrowser open the url with the default browser of the OS
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	return nil
+}
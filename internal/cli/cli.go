@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/momokii/ss-watcher/internal/config"
+	"github.com/momokii/ss-watcher/pkg/utils"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// registered here and not on the var declaration because help need to read the slice itself
+	commands = []command{
+		{name: "watch", usage: "watch a folder and sync every new/removed file to GDrive", run: runWatch},
+		{name: "share", usage: "give users access to the base folder on GDrive", run: runShare},
+		{name: "records", usage: "list the files stored on the records table", run: runRecords},
+		{name: "queue", usage: "list the uploads and deletes waiting on the queue", run: runQueue},
+		{name: "sync", usage: "upload and remove what the watcher missed while stopped (--full also compare the storage)", run: runSync},
+		{name: "verify", usage: "check that the uploaded files are on the storage as the records say (-repair)", run: runVerify},
+		{name: "dedupe", usage: "report the files with the same content, skipped, linked or uploaded more than once (-backfill)", run: runDedupe},
+		{name: "similar", usage: "list the clusters of screenshots that look alike (-backfill)", run: runSimilar},
+		{name: "doctor", usage: "check credentials, database and watch path", run: runDoctor},
+		{name: "auth", usage: "log in with a google account instead of the service account (login, status, logout)", run: runAuth},
+		{name: "config", usage: "config file helpers (validate)", run: runConfig},
+		{name: "rules", usage: "explain which include/exclude rule match a file (test)", run: runRules},
+		{name: "help", usage: "show this help", run: runHelp},
+	}
+}
+
+// Run execute the subcommand on args[0] with the rest of args as the flags and return the exit code
+func Run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	name := args[0]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		if err := cmd.run(args[1:]); err != nil {
+			if err == flag.ErrHelp {
+				return 0
+			}
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+func runHelp(args []string) error {
+	printUsage(os.Stdout)
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ss-watcher <command> [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w, "\nRun 'ss-watcher <command> -h' to see the flags of each command.")
+}
+
+// newFlagSet create flag set for subcommand, errors are returned instead of exit the process
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ss-watcher "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// commonFlags are the flags shared by every command, non empty value override the config file and env
+type commonFlags struct {
+	config      string
+	credentials string
+	db          string
+}
+
+func (f *commonFlags) bind(fs *flag.FlagSet) {
+	fs.StringVar(&f.config, "config", "", "path to the config file (default $"+config.EnvFile+" or ./"+config.DefaultFile+")")
+	fs.StringVar(&f.credentials, "credentials", "", "path to the google service account JSON file")
+	fs.StringVar(&f.db, "db", "", "path to the sqlite database file")
+}
+
+// load the config file + env and then apply the flags on top of it
+func (f *commonFlags) load() (*config.Config, error) {
+	cfg, err := config.Load(f.config)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.credentials != "" {
+		cfg.Credentials = f.credentials
+	}
+	if f.db != "" {
+		cfg.Database = f.db
+	}
+
+	return cfg, nil
+}
+
+// stringList is flag value that can be repeated and/or filled with comma separated values
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, utils.SplitList(value)...)
+	return nil
+}
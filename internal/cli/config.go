@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/momokii/ss-watcher/internal/config"
+)
+
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("usage: ss-watcher config validate [-config file]")
+	}
+
+	var common commonFlags
+
+	fs := newFlagSet("config validate")
+	common.bind(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	source := cfg.Source
+	if source == "" {
+		source = "(defaults and env only)"
+	}
+
+	fmt.Println("Config file :", source)
+	fmt.Println("Credentials :", cfg.Credentials)
+	if cfg.OAuth != nil && cfg.OAuth.ClientSecret != "" {
+		t, _ := cfg.Target(config.DefaultTarget)
+		fmt.Println("OAuth client:", cfg.OAuth.ClientSecret)
+		fmt.Println("OAuth token :", t.GDrive.OAuth.Token)
+	}
+	fmt.Println("Database    :", cfg.Database)
+	fmt.Println("Base folder :", cfg.Drive.BaseFolder)
+	fmt.Println("Daily prefix:", cfg.Drive.DailyPrefix)
+	fmt.Println("Share       :", strings.Join(cfg.Drive.Share, ", "))
+	revisions := "drive default (30 days)"
+	if cfg.Drive.KeepRevisions {
+		revisions = "kept forever"
+	}
+	fmt.Println("Revisions   :", revisions)
+	fmt.Printf("Workers     : %d, drain timeout %s\n", cfg.Queue.Workers, cfg.Queue.DrainTimeout)
+	fmt.Printf("Dedupe      : %s, similar %s (distance %d, window %s)\n", cfg.Dedupe.Policy, cfg.Dedupe.Similar.Policy, cfg.Dedupe.Similar.Distance, cfg.Dedupe.Similar.Window)
+	fmt.Printf("Sync        : on start %t, orphans %s\n", cfg.Sync.OnStart, cfg.Sync.Orphans)
+	fmt.Printf("Retry       : every %s, %d attempts per request (%s - %s)\n", cfg.Retry.Interval, cfg.Retry.Attempts, cfg.Retry.BaseDelay, cfg.Retry.MaxDelay)
+
+	for i, w := range cfg.ResolvedWatches() {
+		fmt.Printf("\nWatch #%d\n", i+1)
+		fmt.Println("  Path        :", w.Path)
+		fmt.Println("  Base folder :", w.BaseFolder)
+		fmt.Println("  Daily prefix:", w.DailyPrefix)
+		fmt.Println("  Share       :", strings.Join(w.Share, ", "))
+		fmt.Println("  Targets     :", strings.Join(w.Targets, ", "))
+	}
+	fmt.Println()
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	fmt.Println("Config is valid")
+	return nil
+}
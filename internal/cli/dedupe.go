@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/internal/repository"
+	"github.com/momokii/ss-watcher/pkg/checksum"
+	"github.com/momokii/ss-watcher/pkg/utils"
+)
+
+// runDedupe report the files with the same content on a target, the duplicates not uploaded (dedupe policy) and
+// the copies uploaded more than once
+func runDedupe(args []string) error {
+	var common commonFlags
+	var backfill bool
+
+	fs := newFlagSet("dedupe")
+	fs.BoolVar(&backfill, "backfill", false, "hash the local files of the records uploaded before the hashes were stored (the local file must not have changed since)")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	recordRepo := repository.NewRecordsRepository()
+
+	if backfill {
+		if err := backfillHashes(db, recordRepo); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	records, err := recordRepo.FindAll(tx)
+	tx.Rollback()
+	if err != nil {
+		return fmt.Errorf("Error Find All Records: %v", err)
+	}
+
+	// records with the same content on the same target, key is <target>|<sha256>, in the order of the first upload
+	groups := make(map[string][]models.Records)
+	var keys []string
+	unhashed := 0
+	for _, record := range *records {
+		if record.Status != models.RecordUploaded && record.Status != models.RecordDuplicate {
+			continue
+		}
+		if record.SHA256 == "" {
+			unhashed++
+			continue
+		}
+
+		key := record.Target + "|" + record.SHA256
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], record)
+	}
+
+	var found, uploaded, skipped int
+	var wasted, saved int64
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tTARGET\tSHA256\tSIZE\tID\tWATCH\tPATH\tSTATUS")
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		found++
+
+		for i, record := range group {
+			status := record.Status
+			switch {
+			case record.Status == models.RecordDuplicate:
+				status = fmt.Sprintf("%s of #%d", record.Status, record.DuplicateOf)
+				if record.ItemID != "" {
+					status += ", linked"
+				}
+				skipped++
+				saved += record.Size
+			case i > 0:
+				status += ", uploaded again"
+				uploaded++
+				wasted += record.Size
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", found, record.Target, record.SHA256[:12], utils.FormatSize(record.Size), record.ID, record.Watch, record.Path, status)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d group(s) of identical files\n", found)
+	fmt.Printf("%d duplicate(s) not uploaded, %s saved\n", skipped, utils.FormatSize(saved))
+	fmt.Printf("%d file(s) uploaded again with the same content, %s on the storage\n", uploaded, utils.FormatSize(wasted))
+	if unhashed > 0 {
+		fmt.Printf("%d record(s) uploaded before the hashes were stored are not compared, run 'ss-watcher dedupe -backfill'\n", unhashed)
+	}
+
+	return nil
+}
+
+// backfillHashes hash the local file of the uploaded records without hashes
+func backfillHashes(db *sql.DB, recordRepo repository.RecordRepository) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	records, err := recordRepo.FindByStatus(tx, models.RecordUploaded)
+	tx.Rollback()
+	if err != nil {
+		return fmt.Errorf("Error Find By Status: %v", err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, record := range *records {
+		// records of the older version has no watch, the local path is unknown
+		if record.SHA256 != "" || record.Watch == "" {
+			continue
+		}
+
+		sum, err := checksum.File(filepath.Join(record.Watch, filepath.FromSlash(record.Path)))
+		if err != nil {
+			fmt.Printf("Skip '%s': %v\n", record.Path, err)
+			continue
+		}
+
+		record.Size = sum.Size
+		record.MD5 = sum.MD5
+		record.SHA256 = sum.SHA256
+		if err := recordRepo.Update(tx, &record); err != nil {
+			return fmt.Errorf("Error Store Record: %v", err)
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	fmt.Printf("Hashed %d record(s)\n\n", count)
+	return nil
+}
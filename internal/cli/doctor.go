@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/momokii/ss-watcher/internal/config"
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/pkg/gdrive"
+)
+
+func runDoctor(args []string) error {
+	var common commonFlags
+	var path string
+
+	fs := newFlagSet("doctor")
+	fs.StringVar(&path, "path", "", "folder to check instead of the watch entries from the config file")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		cfg.Watches = []config.WatchConfig{{Path: path}}
+	}
+
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("[FAIL] %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("[ OK ] %s\n", name)
+	}
+
+	// watch paths
+	for _, w := range cfg.ResolvedWatches() {
+		_, err := resolveWatchPath(w.Path)
+		check("watch path "+w.Path, err)
+	}
+
+	// database
+	db, err := database.InitDB(cfg.Database)
+	if err == nil {
+		err = db.Ping()
+		db.Close()
+	}
+	check("database "+cfg.Database, err)
+
+	// every target used by the watch entries
+	for _, name := range cfg.UsedTargets() {
+		t, err := cfg.Target(name)
+		if err != nil {
+			check("target "+name, err)
+			continue
+		}
+
+		// oauth client and token of drive target logged in with a google account
+		if t.UseOAuth() {
+			if _, err := os.Stat(t.GDrive.OAuth.ClientSecret); err != nil {
+				check("target "+name+" oauth client secret", fmt.Errorf("cannot read '%s': %v", t.GDrive.OAuth.ClientSecret, err))
+				continue
+			}
+			check("target "+name+" oauth client secret "+t.GDrive.OAuth.ClientSecret, nil)
+
+			if _, err := gdrive.LoadToken(t.GDrive.OAuth.Token); err != nil {
+				check("target "+name+" oauth token", err)
+				continue
+			}
+			check("target "+name+" oauth token "+t.GDrive.OAuth.Token, nil)
+
+			// credentials file of drive target
+		} else if t.Type == config.TargetGDrive {
+			if _, err := os.Stat(t.GDrive.Credentials); err != nil {
+				check("target "+name+" credentials file", fmt.Errorf("cannot read '%s': %v", t.GDrive.Credentials, err))
+				continue
+			}
+			check("target "+name+" credentials file "+t.GDrive.Credentials, nil)
+		}
+
+		// opening the storage already check the connection (ex: s3 bucket exist), drive need one request
+		store, err := openTarget(cfg, name, nil)
+		if gd, ok := store.(gdrive.GDrive); ok && err == nil {
+			_, err = gd.CheckFolderExist(cfg.Drive.BaseFolder, "")
+		}
+		check(fmt.Sprintf("target %s (%s) access", name, t.Type), err)
+
+		// sftp keep the ssh connection open until closed
+		if closer, ok := store.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+
+	return nil
+}
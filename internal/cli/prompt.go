@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// isInteractive report whether stdin is attached to a terminal, prompts are only used on that case
+// so the binary can still be run from scripts, systemd or CI without blocking
+func isInteractive() bool {
+	fd := os.Stdin.Fd()
+	return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+}
+
+// prompt print the question and read one line from stdin
+func prompt(question string) string {
+	fmt.Println(question)
+
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return ""
+	}
+
+	return strings.TrimSpace(line)
+}
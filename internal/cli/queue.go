@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/repository"
+)
+
+func runQueue(args []string) error {
+	var common commonFlags
+
+	fs := newFlagSet("queue")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := repository.NewJobsRepository().FindAll(tx)
+	if err != nil {
+		return fmt.Errorf("Error Find All Jobs: %v", err)
+	}
+
+	if len(*jobs) == 0 {
+		fmt.Println("Queue is empty")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tWATCH\tPATH\tTARGET\tATTEMPTS\tNEXT RUN\tLAST ERROR")
+	for _, job := range *jobs {
+		path := job.Path
+		if job.FromPath != "" {
+			path = job.FromPath + " -> " + job.Path
+		}
+		next := time.Unix(job.RunAt, 0).Format(time.DateTime)
+		if job.StartedAt != 0 {
+			next = "running"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", job.ID, job.Kind, job.Watch, path, job.Target, job.Attempts, next, job.Error)
+	}
+
+	return w.Flush()
+}
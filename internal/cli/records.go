@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/internal/repository"
+)
+
+func runRecords(args []string) error {
+	var common commonFlags
+
+	fs := newFlagSet("records")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	records, err := repository.NewRecordsRepository().FindAll(tx)
+	if err != nil {
+		return fmt.Errorf("Error Find All Records: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tWATCH\tPATH\tTARGET\tSTATUS\tMIME TYPE\tITEM ID\tFOLDER ID\tDATE")
+	for _, record := range *records {
+		status := record.Status
+		if record.Status == models.RecordFailed || record.Status == models.RecordRejected {
+			status = fmt.Sprintf("%s (%d): %s", record.Status, record.Attempts, record.Error)
+		} else if record.Status == models.RecordDuplicate {
+			status = fmt.Sprintf("%s of #%d", record.Status, record.DuplicateOf)
+		} else if record.Status == models.RecordSimilar {
+			status = fmt.Sprintf("%s to #%d", record.Status, record.SimilarTo)
+		} else if record.SimilarTo != 0 {
+			status = fmt.Sprintf("%s, similar to #%d", record.Status, record.SimilarTo)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.ID, record.Watch, record.Path, record.Target, status, record.MimeType, record.ItemID, record.FolderID, record.Date)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/momokii/ss-watcher/internal/config"
+)
+
+func runRules(args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return fmt.Errorf("usage: ss-watcher rules test [-config file] <path>...")
+	}
+
+	var common commonFlags
+
+	fs := newFlagSet("rules test")
+	common.bind(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: ss-watcher rules test [-config file] <path>...")
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		if err := explainRules(cfg, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// explainRules print which watch entry own the path and which rule decide whether the file is synced
+func explainRules(cfg *config.Config, path string) error {
+	absPath, err := filepath.Abs(filepath.FromSlash(path))
+	if err != nil {
+		return fmt.Errorf("Error Abs Path: %v", err)
+	}
+
+	// file that does not exist (yet) is checked without the size filters
+	size := int64(-1)
+	if info, err := os.Stat(absPath); err == nil {
+		size = info.Size()
+	}
+
+	// find the watch entry of the path, if not inside any of them use the top level rules with the name only
+	rulesConfig := cfg.Rules
+	rel := filepath.Base(absPath)
+	watch := "(none, top level rules)"
+	for _, w := range cfg.ResolvedWatches() {
+		r, err := filepath.Rel(w.Path, absPath)
+		if err != nil || r == "." || strings.HasPrefix(r, "..") {
+			continue
+		}
+		rulesConfig = *w.Rules
+		rel = filepath.ToSlash(r)
+		watch = w.Path
+		break
+	}
+
+	rules, err := rulesConfig.Compile()
+	if err != nil {
+		return err
+	}
+
+	result := rules.Match(rel, size)
+
+	status := "SYNC"
+	if !result.Allowed {
+		status = "SKIP"
+	}
+
+	fmt.Println(absPath)
+	fmt.Println("  Watch   :", watch)
+	fmt.Println("  Relative:", rel)
+	if size >= 0 {
+		fmt.Println("  Size    :", size, "bytes")
+	} else {
+		fmt.Println("  Size    : (file not found, size filters skipped)")
+	}
+	fmt.Printf("  Result  : %s, %s\n", status, result.Reason)
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/internal/repository"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// sessionStore keep the resumable upload sessions of the storage on the upload_sessions table
+type sessionStore struct {
+	db   *sql.DB
+	repo repository.UploadSessionRepository
+}
+
+func newSessionStore(db *sql.DB) storage.UploadSessionStore {
+	return &sessionStore{
+		db:   db,
+		repo: repository.NewUploadSessionRepository(),
+	}
+}
+
+func (s *sessionStore) GetSession(key string) (*storage.UploadSession, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	session, err := s.repo.FindByKey(tx, key)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &storage.UploadSession{
+		Key:     session.SessionKey,
+		URI:     session.URI,
+		Size:    session.Size,
+		ModTime: time.Unix(0, session.ModTime),
+		Created: time.Unix(session.CreatedAt, 0),
+		Folder:  session.FolderID,
+	}, nil
+}
+
+func (s *sessionStore) SaveSession(session *storage.UploadSession) error {
+	return s.exec(func(tx *sql.Tx) error {
+		return s.repo.Save(tx, &models.UploadSession{
+			SessionKey: session.Key,
+			URI:        session.URI,
+			Size:       session.Size,
+			ModTime:    session.ModTime.UnixNano(),
+			CreatedAt:  session.Created.Unix(),
+			FolderID:   session.Folder,
+		})
+	})
+}
+
+func (s *sessionStore) DeleteSession(key string) error {
+	return s.exec(func(tx *sql.Tx) error {
+		return s.repo.Delete(tx, key)
+	})
+}
+
+func (s *sessionStore) exec(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/momokii/ss-watcher/internal/config"
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/internal/repository"
+	"github.com/momokii/ss-watcher/pkg/storage"
+	"github.com/momokii/ss-watcher/pkg/utils"
+)
+
+func runShare(args []string) error {
+	var common commonFlags
+	var emails stringList
+
+	fs := newFlagSet("share")
+	fs.Var(&emails, "email", "email to give access to the base folder (repeatable or comma separated)")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	if len(emails) > 0 {
+		cfg.Drive.Share = emails
+	}
+
+	if err := resolveDefaultShare(cfg); err != nil {
+		return err
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	targets := newTargetSet(cfg, db)
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	// share the base folder of every watch entry, -email replace the share list of all of them
+	type baseFolder struct{ target, name string }
+	baseFolders := make(map[baseFolder][]string)
+	for _, w := range cfg.ResolvedWatches() {
+		if len(emails) > 0 {
+			w.Share = emails
+		}
+		for _, name := range w.Targets {
+			key := baseFolder{name, w.BaseFolder}
+			baseFolders[key] = append(baseFolders[key], w.Share...)
+		}
+	}
+	if len(baseFolders) == 0 {
+		baseFolders[baseFolder{config.DefaultTarget, cfg.Drive.BaseFolder}] = cfg.Drive.Share
+	}
+
+	for folder, folderEmails := range baseFolders {
+		fmt.Printf("\nSharing %s '%s'\n", folder.target, folder.name)
+
+		store, err := targets.get(folder.target)
+		if err != nil {
+			return err
+		}
+
+		if _, err := ensureBaseFolder(ctx, store, db, folder.name, utils.Unique(folderEmails)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveEmails validate the emails from flags, and if empty ask the user when running on terminal
+func resolveEmails(emails []string) ([]string, error) {
+	if len(emails) == 0 && isInteractive() {
+		email := prompt("\nEnter the email of the user you want to give permission to access the folder on GDrive: ")
+		if email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	if len(emails) == 0 {
+		return nil, fmt.Errorf("The email is empty, use -email flag")
+	}
+
+	// simple email checker structure
+	for _, email := range emails {
+		if valid, _ := utils.IsEmailFormatValid(email); !valid {
+			return nil, fmt.Errorf("Invalid email format: '%s'", email)
+		}
+	}
+
+	return emails, nil
+}
+
+// resolveDefaultShare make sure drive.share is filled when there is a base folder that depend on it,
+// every service account base folder need at least one user or the folder is only visible to the service account,
+// folder on the drive of the logged in user (oauth) and other targets do not need it
+func resolveDefaultShare(cfg *config.Config) error {
+	needDefault := len(cfg.Watches) == 0
+	for _, w := range cfg.ResolvedWatches() {
+		if len(w.Share) == 0 && usesServiceAccount(cfg, w.Targets) {
+			needDefault = true
+			break
+		}
+	}
+
+	if !needDefault {
+		return nil
+	}
+
+	emails, err := resolveEmails(cfg.Drive.Share)
+	if err != nil {
+		return err
+	}
+	cfg.Drive.Share = emails
+
+	return nil
+}
+
+// usesServiceAccount report whether one of the targets is google drive with service account,
+// unknown target count as one so the error is reported later
+func usesServiceAccount(cfg *config.Config, names []string) bool {
+	for _, name := range names {
+		if t, err := cfg.Target(name); err != nil || (t.Type == config.TargetGDrive && !t.UseOAuth()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureBaseFolder check base folder on the storage exist or not, if not exist create base folder for upload the ss file
+// and make sure all the emails have permission to access it. return the base folder id
+func ensureBaseFolder(ctx context.Context, store storage.Storage, db *sql.DB, folderName string, emails []string) (string, error) {
+	permissionRepo := repository.NewUserPermission()
+
+	// check BASE FOLDER exist or not, created on the root if not exist
+	id, err := store.EnsureFolder(ctx, "", folderName)
+	if err != nil {
+		return "", fmt.Errorf("Error Check Or Create Base Folder: %v", err)
+	}
+
+	// share is no-op for email that already have access, so the owner can access the folder on their storage.
+	// the storage is called before the transaction, the database is not held while waiting for the network
+	var granted []models.UserPermission
+	for _, email := range emails {
+		permission_id, err := store.Share(ctx, id, email)
+		if errors.Is(err, storage.ErrNotSupported) {
+			fmt.Printf("Storage '%s' does not support share per email, skip sharing\n", store.Kind())
+			break
+		} else if err != nil {
+			fmt.Printf("Error Share Folder to '%s': %v\n", email, err)
+			continue
+		}
+
+		granted = append(granted, models.UserPermission{PermissionID: permission_id, Email: email})
+	}
+
+	// start tx for permission access process
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, permission := range granted {
+		// add new data to db user permission if not recorded yet
+		recorded, err := permissionRepo.FindByID(tx, []string{`'` + strings.ReplaceAll(permission.PermissionID, `'`, `''`) + `'`})
+		if err != nil {
+			return "", fmt.Errorf("Error Find By ID: %v", err)
+		}
+
+		is_recorded := false
+		for _, perm := range *recorded {
+			if perm.Email == permission.Email {
+				is_recorded = true
+				break
+			}
+		}
+
+		if !is_recorded {
+			if err := permissionRepo.Create(tx, &permission); err != nil {
+				return "", fmt.Errorf("Error Create Permission: %v", err)
+			}
+		}
+
+		fmt.Printf("User '%s' have permission to the folder '%s'\n", permission.Email, folderName)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return id, nil
+}
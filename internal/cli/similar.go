@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/internal/repository"
+	"github.com/momokii/ss-watcher/internal/watcher"
+	"github.com/momokii/ss-watcher/pkg/imagehash"
+)
+
+// runSimilar list the clusters of screenshots that look alike, from the image hashes on the records table
+func runSimilar(args []string) error {
+	var common commonFlags
+	var distance int
+	var window time.Duration
+	var backfill bool
+
+	fs := newFlagSet("similar")
+	fs.IntVar(&distance, "distance", -1, "max different bits of the image hash (default dedupe.similar.distance from the config)")
+	fs.DurationVar(&window, "window", 0, "max time between two screenshots of a cluster (default dedupe.similar.window from the config)")
+	fs.BoolVar(&backfill, "backfill", false, "hash the local images of the records without image hash (uploaded before or with the policy none)")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	if distance >= 0 {
+		cfg.Dedupe.Similar.Distance = distance
+	}
+	if window > 0 {
+		cfg.Dedupe.Similar.Window = window
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	recordRepo := repository.NewRecordsRepository()
+
+	if backfill {
+		if err := backfillImageHashes(db, recordRepo); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	records, err := recordRepo.FindAll(tx)
+	tx.Rollback()
+	if err != nil {
+		return fmt.Errorf("Error Find All Records: %v", err)
+	}
+
+	clusters := watcher.SimilarClusters(*records, cfg.Dedupe.Similar.Distance, cfg.Dedupe.Similar.Window)
+	if len(clusters) == 0 {
+		fmt.Printf("No similar screenshots (distance %d, window %s)\n", cfg.Dedupe.Similar.Distance, cfg.Dedupe.Similar.Window)
+		return nil
+	}
+
+	skipped := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tTARGET\tID\tWATCH\tPATH\tTAKEN\tDISTANCE\tSTATUS")
+	for i, cluster := range clusters {
+		for j, record := range cluster.Records {
+			if record.Status == models.RecordSimilar {
+				skipped++
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%d\t%s\n", i+1, cluster.Target, record.ID, record.Watch, record.Path, time.Unix(record.ModTime, 0).Format(time.DateTime), cluster.Distances[j], record.Status)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d cluster(s) of similar screenshots, %d not uploaded by the similar policy\n", len(clusters), skipped)
+	return nil
+}
+
+// backfillImageHashes hash the local image of the uploaded records without image hash
+func backfillImageHashes(db *sql.DB, recordRepo repository.RecordRepository) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	records, err := recordRepo.FindByStatus(tx, models.RecordUploaded)
+	tx.Rollback()
+	if err != nil {
+		return fmt.Errorf("Error Find By Status: %v", err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, record := range *records {
+		// records of the older version has no watch, the local path is unknown
+		if record.PHash != "" || record.Watch == "" || !strings.HasPrefix(record.MimeType, "image/") {
+			continue
+		}
+
+		localPath := filepath.Join(record.Watch, filepath.FromSlash(record.Path))
+		info, err := os.Stat(localPath)
+		if err != nil {
+			fmt.Printf("Skip '%s': %v\n", record.Path, err)
+			continue
+		}
+
+		hash, err := imagehash.File(localPath)
+		if errors.Is(err, image.ErrFormat) {
+			continue
+		} else if err != nil {
+			fmt.Printf("Skip '%s': %v\n", record.Path, err)
+			continue
+		}
+
+		record.PHash = hash.String()
+		record.ModTime = info.ModTime().Unix()
+		if err := recordRepo.Update(tx, &record); err != nil {
+			return fmt.Errorf("Error Store Record: %v", err)
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	fmt.Printf("Hashed %d image(s)\n\n", count)
+	return nil
+}
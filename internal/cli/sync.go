@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/repository"
+	"github.com/momokii/ss-watcher/internal/watcher"
+)
+
+func runSync(args []string) error {
+	var common commonFlags
+	var full, dryRun bool
+	var orphans string
+
+	fs := newFlagSet("sync")
+	fs.BoolVar(&full, "full", false, "also list the daily folders on the storage, not only the local folders and the records")
+	fs.BoolVar(&dryRun, "dry-run", false, "only report the differences, nothing is uploaded or deleted")
+	fs.StringVar(&orphans, "orphans", "", "remote file without local file: report, keep or delete (default sync.orphans from the config)")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	if orphans != "" {
+		cfg.Sync.Orphans = orphans
+	}
+
+	if len(cfg.Watches) == 0 {
+		return fmt.Errorf("No watch entry on the config file")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Ctrl-C stop the queued jobs like the watcher, the running ones are drained
+	ctx, stop := signalContext()
+	defer stop()
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := buildEntries(ctx, cfg, db, false)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+
+	w := watcher.New(db, entries, watcherOptions(cfg))
+
+	report, err := w.Reconcile(ctx, watcher.ReconcileOptions{
+		Full:    full,
+		Orphans: cfg.Sync.Orphans,
+		DryRun:  dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if len(report.Drifts) == 0 {
+		fmt.Println("Everything is in sync")
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "DRIFT\tTARGET\tWATCH\tPATH\tREMOTE ID\tACTION")
+		for _, d := range report.Drifts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Kind, d.Target, d.Watch, d.Path, d.RemoteID, d.Action)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for name, reason := range report.Skipped {
+		fmt.Printf("Storage of '%s' not compared: %s\n", name, reason)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	// run the queued uploads and deletes now, the failed ones stay for the watcher
+	fmt.Println()
+	w.RunQueue(ctx)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := repository.NewJobsRepository().FindAll(tx)
+	if err != nil {
+		return fmt.Errorf("Error Find All Jobs: %v", err)
+	}
+	if len(*jobs) > 0 {
+		fmt.Printf("\n%d job(s) left on the queue, see 'ss-watcher queue'\n", len(*jobs))
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/momokii/ss-watcher/internal/config"
+	"github.com/momokii/ss-watcher/pkg/gdrive"
+	"github.com/momokii/ss-watcher/pkg/localfs"
+	"github.com/momokii/ss-watcher/pkg/s3"
+	"github.com/momokii/ss-watcher/pkg/sftp"
+	"github.com/momokii/ss-watcher/pkg/storage"
+	"github.com/momokii/ss-watcher/pkg/webdav"
+)
+
+// targetSet open the storage of each target once, so the watch entries with the same target share the connection
+type targetSet struct {
+	cfg    *config.Config
+	db     *sql.DB
+	opened map[string]storage.Storage
+}
+
+func newTargetSet(cfg *config.Config, db *sql.DB) *targetSet {
+	return &targetSet{
+		cfg:    cfg,
+		db:     db,
+		opened: make(map[string]storage.Storage),
+	}
+}
+
+func (t *targetSet) get(name string) (storage.Storage, error) {
+	if store, ok := t.opened[name]; ok {
+		return store, nil
+	}
+
+	store, err := openTarget(t.cfg, name, t.db)
+	if err != nil {
+		return nil, fmt.Errorf("target '%s': %v", name, err)
+	}
+	t.opened[name] = store
+
+	return store, nil
+}
+
+// openTarget create the storage backend of the target name, db keep the resumable upload sessions and can be nil
+func openTarget(cfg *config.Config, name string, db *sql.DB) (storage.Storage, error) {
+	t, err := cfg.Target(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.Type {
+	case config.TargetGDrive:
+		chunkSize, err := cfg.Drive.ChunkSizeBytes()
+		if err != nil {
+			return nil, fmt.Errorf("drive.chunk_size: %v", err)
+		}
+
+		gcfg := gdrive.Config{
+			Name:               name,
+			ServiceAccountPath: t.GDrive.Credentials,
+			DailyFolderPrefix:  cfg.Drive.DailyPrefix,
+			ChunkSize:          chunkSize,
+			KeepRevisions:      cfg.Drive.KeepRevisions,
+			Retry: gdrive.RetryPolicy{
+				MaxAttempts: cfg.Retry.Attempts,
+				BaseDelay:   cfg.Retry.BaseDelay,
+				MaxDelay:    cfg.Retry.MaxDelay,
+			},
+		}
+		if db != nil {
+			gcfg.Sessions = newSessionStore(db)
+		}
+		if t.UseOAuth() {
+			gcfg.OAuthClientPath = t.GDrive.OAuth.ClientSecret
+			gcfg.TokenPath = t.GDrive.OAuth.Token
+		}
+		return gdrive.NewGDrive(gcfg)
+
+	case config.TargetS3:
+		return s3.NewS3(s3.Config{
+			Endpoint:      t.S3.Endpoint,
+			Region:        t.S3.Region,
+			Bucket:        t.S3.Bucket,
+			Prefix:        t.S3.Prefix,
+			AccessKey:     t.S3.AccessKey,
+			SecretKey:     t.S3.SecretKey,
+			SessionToken:  t.S3.SessionToken,
+			Insecure:      t.S3.Insecure,
+			PathStyle:     t.S3.PathStyle,
+			DailyTemplate: t.S3.DailyTemplate,
+			SSE:           t.S3.SSE,
+			SSEKMSKeyID:   t.S3.SSEKMSKeyID,
+			LinkExpiry:    t.S3.LinkExpiry,
+		})
+
+	case config.TargetWebDAV:
+		return webdav.NewWebDAV(webdav.Config{
+			URL:      t.WebDAV.URL,
+			Username: t.WebDAV.Username,
+			Password: t.WebDAV.Password,
+			Timeout:  t.WebDAV.Timeout,
+		})
+
+	case config.TargetLocal:
+		return localfs.NewLocalFS(localfs.Config{
+			Root: t.Local.Path,
+		})
+
+	case config.TargetSFTP:
+		return sftp.NewSFTP(sftp.Config{
+			Host:          t.SFTP.Host,
+			User:          t.SFTP.User,
+			KeyPath:       t.SFTP.KeyPath,
+			KeyPassphrase: t.SFTP.KeyPassphrase,
+			KnownHosts:    t.SFTP.KnownHosts,
+			Root:          t.SFTP.Root,
+			Timeout:       t.SFTP.Timeout,
+		})
+
+	default:
+		return nil, fmt.Errorf("unknown target type '%s'", t.Type)
+	}
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/repository"
+	"github.com/momokii/ss-watcher/internal/watcher"
+)
+
+func runVerify(args []string) error {
+	var common commonFlags
+	var repair bool
+	var target string
+
+	fs := newFlagSet("verify")
+	fs.BoolVar(&repair, "repair", false, "upload the missing, trashed and corrupted files again from the local copy and move the misplaced files back, the other queued jobs are left to the watcher")
+	fs.StringVar(&target, "target", "", "only check the files of this target")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Watches) == 0 {
+		return fmt.Errorf("No watch entry on the config file")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Ctrl-C stop the check, and the queued uploads of the repair like the watcher
+	ctx, stop := signalContext()
+	defer stop()
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := buildEntries(ctx, cfg, db, false)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+
+	w := watcher.New(db, entries, watcherOptions(cfg))
+
+	report, err := w.Verify(ctx, watcher.VerifyOptions{
+		Target: target,
+		Repair: repair,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if len(report.Problems) > 0 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROBLEM\tTARGET\tWATCH\tPATH\tREMOTE ID\tDETAIL\tACTION")
+		for _, p := range report.Problems {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.Kind, p.Target, p.Watch, p.Path, p.RemoteID, p.Detail, p.Action)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Checked %d file(s), %d problem(s)\n", report.Checked, len(report.Problems))
+	if report.NotConfigured > 0 {
+		fmt.Printf("%d record(s) of a watch or target not on the config are not checked\n", report.NotConfigured)
+	}
+
+	if !repair {
+		if len(report.Problems) > 0 {
+			return fmt.Errorf("%d problem(s) found, run with -repair to fix them from the local copies", len(report.Problems))
+		}
+		return nil
+	}
+
+	// run the uploads of the repair now, the failed ones and the other jobs of the queue stay for the watcher
+	if len(report.Jobs) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w.RunJobs(ctx, report.Jobs)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := repository.NewJobsRepository().FindAll(tx)
+	if err != nil {
+		return fmt.Errorf("Error Find All Jobs: %v", err)
+	}
+
+	repairJobs := make(map[int]bool, len(report.Jobs))
+	for _, id := range report.Jobs {
+		repairJobs[id] = true
+	}
+	left := 0
+	for _, job := range *jobs {
+		if repairJobs[job.ID] {
+			left++
+		}
+	}
+	if left > 0 {
+		fmt.Printf("\n%d repair upload(s) left on the queue, they run with the watcher, see 'ss-watcher queue'\n", left)
+	}
+
+	return nil
+}
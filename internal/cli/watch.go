@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/momokii/ss-watcher/internal/config"
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/watcher"
+)
+
+func runWatch(args []string) error {
+	var common commonFlags
+	var path string
+	var emails stringList
+
+	fs := newFlagSet("watch")
+	fs.StringVar(&path, "path", "", "folder to watch, replace the watch entries from the config file")
+	fs.Var(&emails, "email", "email to give access to the base folder (repeatable or comma separated)")
+	common.bind(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := common.load()
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		cfg.Watches = []config.WatchConfig{{Path: path}}
+	}
+	if len(emails) > 0 {
+		cfg.Drive.Share = emails
+	}
+
+	// nothing on flag and config, ask the user when running on terminal
+	if len(cfg.Watches) == 0 {
+		PATH, err := resolveWatchPath("")
+		if err != nil {
+			return err
+		}
+		cfg.Watches = []config.WatchConfig{{Path: PATH}}
+	}
+
+	if err := resolveDefaultShare(cfg); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	// Ctrl-C stop the watcher gracefully, the running uploads are drained and the queue is kept for the next start
+	ctx, stop := signalContext()
+	defer stop()
+
+	// * ------------ INIT DATABASE PROCESS INIT
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	fmt.Println()
+
+	// * ------------ STORAGE PROCESS CHECKER FOLDER AND PERMISSION ACCESS
+	entries, err := buildEntries(ctx, cfg, db, true)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+
+	// * ------------ WATCHER PROCESS MAIN LOOP
+	return watcher.New(db, entries, watcherOptions(cfg)).Run(ctx)
+}
+
+// signalContext return a context canceled on the first SIGINT/SIGTERM, the next one kill the process as usual
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	return ctx, stop
+}
+
+// buildEntries open the targets of every watch and make sure their base folder exist,
+// with share the base folder is also shared to the emails of the watch
+func buildEntries(ctx context.Context, cfg *config.Config, db *sql.DB, share bool) ([]watcher.Entry, error) {
+	// the storage keep the resumable upload sessions on the database
+	targets := newTargetSet(cfg, db)
+
+	entries := make([]watcher.Entry, 0, len(cfg.Watches))
+	for _, w := range cfg.ResolvedWatches() {
+		rules, err := w.Rules.Compile()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := watcher.Entry{
+			Path:        w.Path,
+			DailyPrefix: w.DailyPrefix,
+			Rules:       rules,
+		}
+
+		for _, name := range w.Targets {
+			fmt.Printf("\nPreparing '%s' -> %s '%s'\n", w.Path, name, w.BaseFolder)
+
+			store, err := targets.get(name)
+			if err != nil {
+				return nil, err
+			}
+
+			var emails []string
+			if share {
+				emails = w.Share
+			}
+
+			baseFolderId, err := ensureBaseFolder(ctx, store, db, w.BaseFolder, emails)
+			if err != nil {
+				return nil, err
+			}
+
+			entry.Targets = append(entry.Targets, watcher.Target{
+				Name:         name,
+				Storage:      store,
+				BaseFolderID: baseFolderId,
+			})
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func watcherOptions(cfg *config.Config) watcher.Options {
+	return watcher.Options{
+		DebounceWindow: cfg.Debounce.Window,
+		MimeTypes:      cfg.MimeTypes,
+		RetryInterval:  cfg.Retry.Interval,
+		Workers:        cfg.Queue.Workers,
+		DrainTimeout:   cfg.Queue.DrainTimeout,
+		SyncOnStart:    cfg.Sync.OnStart,
+		Orphans:        cfg.Sync.Orphans,
+		Dedupe:         cfg.Dedupe.Policy,
+
+		Similar:         cfg.Dedupe.Similar.Policy,
+		SimilarDistance: cfg.Dedupe.Similar.Distance,
+		SimilarWindow:   cfg.Dedupe.Similar.Window,
+	}
+}
+
+// resolveWatchPath take the path from flag (or ask it when running on terminal) and check the path exist on local machine
+func resolveWatchPath(path string) (string, error) {
+	if path == "" && isInteractive() {
+		path = prompt("Enter the absolute path to the screenshot folder you want to watch (ex: C:/Users/ACER): ")
+	}
+
+	if path == "" {
+		return "", fmt.Errorf("The path is empty, use -path flag")
+	}
+
+	// convert absolute path to forward slash and also check if the path exist or not on local machine
+	absPath, err := filepath.Abs(filepath.ToSlash(path))
+	if err != nil {
+		return "", fmt.Errorf("Error Abs Path: %v", err)
+	}
+
+	info, err := os.Stat(absPath)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("The inputted path ('%s') does not exist, try again!", absPath)
+	} else if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("The inputted path ('%s') is not a folder", absPath)
+	}
+
+	return absPath, nil
+}
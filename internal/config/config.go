@@ -0,0 +1,516 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/rules"
+	"github.com/momokii/ss-watcher/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// file loaded when no config path is given and the file exist on the working directory
+const DefaultFile = "ss-watcher.yaml"
+
+// env var to point to the config file
+const EnvFile = "SSW_CONFIG"
+
+// location of the sqlite file when the config does not set one, relative to the working directory
+const DefaultDatabase = "./internal/database/migrations/database.db"
+
+type Config struct {
+	Credentials string         `yaml:"credentials"`
+	Database    string         `yaml:"database"`
+	Drive       DriveConfig    `yaml:"drive"`
+	Rules       RulesConfig    `yaml:"rules"`
+	Debounce    DebounceConfig `yaml:"debounce"`
+	Retry       RetryConfig    `yaml:"retry"`
+	Sync        SyncConfig     `yaml:"sync"`
+	Queue       QueueConfig    `yaml:"queue"`
+	Dedupe      DedupeConfig   `yaml:"dedupe"`
+	// log in with a google account instead of the service account credentials
+	OAuth *OAuthConfig `yaml:"oauth"`
+	// mime type by extension (ex: ".png": "image/png"), used instead of the detected one
+	MimeTypes map[string]string       `yaml:"mime_types"`
+	Targets   map[string]TargetConfig `yaml:"targets"`
+	Watches   []WatchConfig           `yaml:"watches"`
+
+	// path of the loaded file, empty when only defaults and env are used
+	Source string `yaml:"-"`
+
+	// invalid env values, reported by Validate
+	envErrors []string
+}
+
+// DriveConfig hold the default values used by every watch entry that does not set its own
+type DriveConfig struct {
+	BaseFolder  string   `yaml:"base_folder"`
+	DailyPrefix string   `yaml:"daily_prefix"`
+	Share       []string `yaml:"share"`
+	// file bigger than this is uploaded to google drive in chunks that resume after failure, ex: "8MB"
+	ChunkSize string `yaml:"chunk_size"`
+	// keep every revision of the edited files on google drive, drive remove the old ones after 30 days without it
+	KeepRevisions bool `yaml:"keep_revisions"`
+}
+
+// ChunkSizeBytes return drive.chunk_size in bytes, 0 when empty
+func (d DriveConfig) ChunkSizeBytes() (int64, error) {
+	return utils.ParseSize(d.ChunkSize)
+}
+
+// WatchConfig map one local folder to its own base folder on the target storage, empty values use the drive defaults
+type WatchConfig struct {
+	Path        string   `yaml:"path"`
+	BaseFolder  string   `yaml:"base_folder"`
+	DailyPrefix string   `yaml:"daily_prefix"`
+	Share       []string `yaml:"share"`
+	// nil use the top level rules
+	Rules *RulesConfig `yaml:"rules"`
+	// name of the storage target on targets, default DefaultTarget (google drive)
+	Target string `yaml:"target"`
+	// upload every file to all these targets, merged with target
+	Targets []string `yaml:"targets"`
+}
+
+// SyncConfig control the reconciliation between the local folders, the records table and the storage
+type SyncConfig struct {
+	// compare everything when the watcher start, so the files created or removed while it was stopped are synced
+	OnStart bool `yaml:"on_start"`
+	// what to do with the remote file that has no local file: report, keep (forget the record) or delete
+	Orphans string `yaml:"orphans"`
+}
+
+// QueueConfig control how the queued uploads and deletes are run
+type QueueConfig struct {
+	// how many jobs run at the same time, jobs of the same file and target still run in order
+	Workers int `yaml:"workers"`
+	// how long the running uploads can finish on Ctrl-C/SIGTERM before they are canceled, ex: "30s"
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+// DedupeConfig control the files with the same content as a file already uploaded to the target
+type DedupeConfig struct {
+	// skip (not uploaded, only recorded), link (drive shortcut or hard link on local target, skip on the others)
+	// or upload (upload every copy)
+	Policy string `yaml:"policy"`
+	// near duplicate screenshots, not the same content but almost the same picture
+	Similar SimilarConfig `yaml:"similar"`
+}
+
+// SimilarConfig control the screenshots that look like one taken just before, compared by perceptual hash
+type SimilarConfig struct {
+	// none (no image hash), tag (upload and mark it on the record), group (upload to a folder next to the first
+	// screenshot) or skip (not uploaded, only recorded)
+	Policy string `yaml:"policy"`
+	// how many of the 64 bits of the hash can differ, 0 only match the same picture
+	Distance int `yaml:"distance"`
+	// only the screenshots taken this long before are compared, ex: "2m"
+	Window time.Duration `yaml:"window"`
+}
+
+// DebounceConfig control when a written file is considered done and uploaded
+type DebounceConfig struct {
+	// how long the size and mtime of the file must stay the same, ex: "2s"
+	Window time.Duration `yaml:"window"`
+}
+
+// RetryConfig control how the failed uploads are tried again
+type RetryConfig struct {
+	// how long a failed job wait on the upload queue before it is tried again, ex: "1m"
+	Interval time.Duration `yaml:"interval"`
+	// tries of each Google Drive request on rate limit, server or network error before the upload is marked failed
+	Attempts int `yaml:"attempts"`
+	// the wait between the tries grows from base_delay up to max_delay (random jitter), Retry-After is used when longer
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+}
+
+// RulesConfig decide which files get uploaded, see rules.New for the matching order
+type RulesConfig struct {
+	Include      []string `yaml:"include"`
+	Exclude      []string `yaml:"exclude"`
+	IncludeRegex []string `yaml:"include_regex"`
+	ExcludeRegex []string `yaml:"exclude_regex"`
+	MinSize      string   `yaml:"min_size"`
+	MaxSize      string   `yaml:"max_size"`
+}
+
+// Compile parse the sizes and compile the patterns
+func (r RulesConfig) Compile() (*rules.Rules, error) {
+	minSize, err := utils.ParseSize(r.MinSize)
+	if err != nil {
+		return nil, fmt.Errorf("min_size: %v", err)
+	}
+
+	maxSize, err := utils.ParseSize(r.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("max_size: %v", err)
+	}
+
+	return rules.New(r.Include, r.Exclude, r.IncludeRegex, r.ExcludeRegex, minSize, maxSize)
+}
+
+func Default() *Config {
+	return &Config{
+		Database: DefaultDatabase,
+		Drive: DriveConfig{
+			BaseFolder:  "SS-Watcher-Backup-GDrive-Folder",
+			DailyPrefix: "SS_",
+			ChunkSize:   "8MB",
+		},
+		Debounce: DebounceConfig{
+			Window: 2 * time.Second,
+		},
+		Retry: RetryConfig{
+			Interval:  time.Minute,
+			Attempts:  5,
+			BaseDelay: time.Second,
+			MaxDelay:  32 * time.Second,
+		},
+		Sync: SyncConfig{
+			OnStart: true,
+			Orphans: "report",
+		},
+		Queue: QueueConfig{
+			Workers:      4,
+			DrainTimeout: 30 * time.Second,
+		},
+		Dedupe: DedupeConfig{
+			Policy: "skip",
+			Similar: SimilarConfig{
+				Policy:   "none",
+				Distance: 5,
+				Window:   2 * time.Minute,
+			},
+		},
+		Rules: RulesConfig{
+			// editor temp files, OS metadata, thumbnails and partial downloads
+			Exclude: []string{
+				".DS_Store", "._*", "Thumbs.db", "desktop.ini",
+				"*.tmp", "*.temp", "*.swp", "*.swx", "*~", ".~*", "~$*",
+				"*.part", "*.partial", "*.crdownload", "*.download",
+				".thumbnails/**", "**/.thumbnails/**",
+			},
+		},
+	}
+}
+
+// Load read the config file on path (or SSW_CONFIG / ss-watcher.yaml when path is empty) on top of the defaults
+// and then apply the env var overrides
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	explicit := true
+	if path == "" {
+		path = os.Getenv(EnvFile)
+	}
+	if path == "" {
+		path = DefaultFile
+		explicit = false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// default file is optional, explicit one is not
+		if !explicit && os.IsNotExist(err) {
+			cfg.applyEnv()
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("Error Read Config: %v", err)
+	}
+
+	if err := cfg.decode(data); err != nil {
+		return nil, fmt.Errorf("Error Parse Config '%s': %v", path, err)
+	}
+	cfg.Source = path
+
+	cfg.applyEnv()
+	return cfg, nil
+}
+
+func (c *Config) decode(data []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	// unknown keys are most of the time typo, so fail instead of ignore it
+	dec.KnownFields(true)
+
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// env var overrides, each one replace the value from the file
+var envOverrides = []struct {
+	name  string
+	apply func(c *Config, value string)
+}{
+	{"SSW_CREDENTIALS", func(c *Config, v string) { c.Credentials = v }},
+	{"SSW_OAUTH_CLIENT_SECRET", func(c *Config, v string) {
+		if c.OAuth == nil {
+			c.OAuth = &OAuthConfig{}
+		}
+		c.OAuth.ClientSecret = v
+	}},
+	{"SSW_OAUTH_TOKEN", func(c *Config, v string) {
+		if c.OAuth == nil {
+			c.OAuth = &OAuthConfig{}
+		}
+		c.OAuth.Token = v
+	}},
+	{"SSW_DATABASE", func(c *Config, v string) { c.Database = v }},
+	{"SSW_BASE_FOLDER", func(c *Config, v string) { c.Drive.BaseFolder = v }},
+	{"SSW_DAILY_PREFIX", func(c *Config, v string) { c.Drive.DailyPrefix = v }},
+	{"SSW_DEBOUNCE_WINDOW", func(c *Config, v string) { c.envDuration("SSW_DEBOUNCE_WINDOW", v, &c.Debounce.Window) }},
+	{"SSW_RETRY_INTERVAL", func(c *Config, v string) { c.envDuration("SSW_RETRY_INTERVAL", v, &c.Retry.Interval) }},
+	{"SSW_RETRY_ATTEMPTS", func(c *Config, v string) { c.envInt("SSW_RETRY_ATTEMPTS", v, &c.Retry.Attempts) }},
+	{"SSW_SYNC_ON_START", func(c *Config, v string) { c.envBool("SSW_SYNC_ON_START", v, &c.Sync.OnStart) }},
+	{"SSW_SYNC_ORPHANS", func(c *Config, v string) { c.Sync.Orphans = v }},
+	{"SSW_WORKERS", func(c *Config, v string) { c.envInt("SSW_WORKERS", v, &c.Queue.Workers) }},
+	{"SSW_DRAIN_TIMEOUT", func(c *Config, v string) { c.envDuration("SSW_DRAIN_TIMEOUT", v, &c.Queue.DrainTimeout) }},
+	{"SSW_DEDUPE", func(c *Config, v string) { c.Dedupe.Policy = v }},
+	{"SSW_SIMILAR", func(c *Config, v string) { c.Dedupe.Similar.Policy = v }},
+	{"SSW_SIMILAR_DISTANCE", func(c *Config, v string) { c.envInt("SSW_SIMILAR_DISTANCE", v, &c.Dedupe.Similar.Distance) }},
+	{"SSW_SIMILAR_WINDOW", func(c *Config, v string) { c.envDuration("SSW_SIMILAR_WINDOW", v, &c.Dedupe.Similar.Window) }},
+	{"SSW_CHUNK_SIZE", func(c *Config, v string) { c.Drive.ChunkSize = v }},
+	{"SSW_KEEP_REVISIONS", func(c *Config, v string) { c.envBool("SSW_KEEP_REVISIONS", v, &c.Drive.KeepRevisions) }},
+	{"SSW_SHARE", func(c *Config, v string) { c.Drive.Share = utils.SplitList(v) }},
+	// replace all the watch entries from the file with a single one
+	{"SSW_WATCH_PATH", func(c *Config, v string) { c.Watches = []WatchConfig{{Path: v}} }},
+}
+
+// envInt parse the int of the env var into dst, an invalid value is reported by Validate and dst keep its value
+func (c *Config) envInt(name, value string, dst *int) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		c.envErrors = append(c.envErrors, fmt.Sprintf("%s: %v", name, err))
+		return
+	}
+	*dst = n
+}
+
+// envBool parse the bool of the env var into dst, see envInt
+func (c *Config) envBool(name, value string, dst *bool) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		c.envErrors = append(c.envErrors, fmt.Sprintf("%s: %v", name, err))
+		return
+	}
+	*dst = b
+}
+
+// envDuration parse the duration of the env var into dst, see envInt
+func (c *Config) envDuration(name, value string, dst *time.Duration) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		c.envErrors = append(c.envErrors, fmt.Sprintf("%s: %v", name, err))
+		return
+	}
+	*dst = d
+}
+
+func (c *Config) applyEnv() {
+	for _, env := range envOverrides {
+		if value, ok := os.LookupEnv(env.name); ok && value != "" {
+			env.apply(c, value)
+		}
+	}
+}
+
+// Validate check all the values and return every problem found, not only the first one
+func (c *Config) Validate() error {
+	problems := append([]string{}, c.envErrors...)
+
+	if c.Database == "" {
+		problems = append(problems, "database: path is empty")
+	}
+
+	if err := validateDriveName(c.Drive.BaseFolder); err != nil {
+		problems = append(problems, "drive.base_folder: "+err.Error())
+	}
+
+	if err := validateDriveName(c.Drive.DailyPrefix); err != nil {
+		problems = append(problems, "drive.daily_prefix: "+err.Error())
+	}
+
+	for _, email := range c.Drive.Share {
+		if valid, _ := utils.IsEmailFormatValid(email); !valid {
+			problems = append(problems, fmt.Sprintf("drive.share: invalid email format '%s'", email))
+		}
+	}
+
+	// drive only accept chunk of multiple of 256 KB
+	if size, err := c.Drive.ChunkSizeBytes(); err != nil {
+		problems = append(problems, "drive.chunk_size: "+err.Error())
+	} else if size != 0 && (size < 256<<10 || size%(256<<10) != 0) {
+		problems = append(problems, fmt.Sprintf("drive.chunk_size: '%s' must be a multiple of 256KB", c.Drive.ChunkSize))
+	}
+
+	if c.Debounce.Window <= 0 {
+		problems = append(problems, "debounce.window: must be bigger than 0")
+	}
+
+	if c.Retry.Interval <= 0 {
+		problems = append(problems, "retry.interval: must be bigger than 0")
+	}
+	if c.Retry.Attempts < 1 {
+		problems = append(problems, "retry.attempts: must be at least 1")
+	}
+	if c.Retry.BaseDelay <= 0 || c.Retry.MaxDelay < c.Retry.BaseDelay {
+		problems = append(problems, "retry.base_delay/max_delay: must be bigger than 0 and max_delay not smaller than base_delay")
+	}
+	switch c.Sync.Orphans {
+	case "report", "keep", "delete":
+	default:
+		problems = append(problems, fmt.Sprintf("sync.orphans: unknown policy '%s', use report, keep or delete", c.Sync.Orphans))
+	}
+	if c.Queue.Workers < 1 || c.Queue.Workers > 32 {
+		problems = append(problems, "queue.workers: must be between 1 and 32")
+	}
+	if c.Queue.DrainTimeout <= 0 {
+		problems = append(problems, "queue.drain_timeout: must be bigger than 0")
+	}
+	switch c.Dedupe.Policy {
+	case "skip", "link", "upload":
+	default:
+		problems = append(problems, fmt.Sprintf("dedupe.policy: unknown policy '%s', use skip, link or upload", c.Dedupe.Policy))
+	}
+	switch c.Dedupe.Similar.Policy {
+	case "none", "tag", "group", "skip":
+	default:
+		problems = append(problems, fmt.Sprintf("dedupe.similar.policy: unknown policy '%s', use none, tag, group or skip", c.Dedupe.Similar.Policy))
+	}
+	if c.Dedupe.Similar.Distance < 0 || c.Dedupe.Similar.Distance > 32 {
+		problems = append(problems, "dedupe.similar.distance: must be between 0 and 32")
+	}
+	if c.Dedupe.Similar.Window <= 0 {
+		problems = append(problems, "dedupe.similar.window: must be bigger than 0")
+	}
+
+	for ext, mimeType := range c.MimeTypes {
+		if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			problems = append(problems, fmt.Sprintf("mime_types: key '%s' must be lower case extension with the dot (ex: .png)", ext))
+		}
+		if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+			problems = append(problems, fmt.Sprintf("mime_types.%s: invalid mime type '%s'", ext, mimeType))
+		}
+	}
+
+	if _, err := c.Rules.Compile(); err != nil {
+		problems = append(problems, "rules: "+err.Error())
+	}
+
+	seen := make(map[string]int)
+	for i, w := range c.ResolvedWatches() {
+		key := fmt.Sprintf("watches[%d]", i)
+
+		if w.Path == "" {
+			problems = append(problems, key+".path: is empty")
+		} else if info, err := os.Stat(w.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.path: %v", key, err))
+		} else if !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%s.path: '%s' is not a folder", key, w.Path))
+		}
+
+		if other, ok := seen[w.Path]; ok && w.Path != "" {
+			problems = append(problems, fmt.Sprintf("%s.path: same folder as watches[%d]", key, other))
+		}
+		seen[w.Path] = i
+
+		if w.BaseFolder != c.Drive.BaseFolder {
+			if err := validateDriveName(w.BaseFolder); err != nil {
+				problems = append(problems, key+".base_folder: "+err.Error())
+			}
+		}
+
+		if w.DailyPrefix != c.Drive.DailyPrefix {
+			if err := validateDriveName(w.DailyPrefix); err != nil {
+				problems = append(problems, key+".daily_prefix: "+err.Error())
+			}
+		}
+
+		for _, email := range w.Share {
+			if valid, _ := utils.IsEmailFormatValid(email); !valid {
+				problems = append(problems, fmt.Sprintf("%s.share: invalid email format '%s'", key, email))
+			}
+		}
+
+		for _, name := range w.Targets {
+			if name == "" {
+				problems = append(problems, key+".targets: target name is empty")
+			}
+		}
+
+		if w.Rules != &c.Rules {
+			if _, err := w.Rules.Compile(); err != nil {
+				problems = append(problems, key+".rules: "+err.Error())
+			}
+		}
+	}
+
+	problems = append(problems, c.validateTargets()...)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	return nil
+}
+
+// names are used inside drive query string, so quote and backslash are not allowed
+func validateDriveName(name string) error {
+	if name == "" {
+		return fmt.Errorf("is empty")
+	}
+	if strings.ContainsAny(name, `'\/`) {
+		return fmt.Errorf("'%s' cannot contain quote, slash or backslash", name)
+	}
+	return nil
+}
+
+// ResolvedWatches return the watch entries with the empty values filled from the drive defaults and top level rules
+// and the path converted to absolute path
+func (c *Config) ResolvedWatches() []WatchConfig {
+	watches := make([]WatchConfig, 0, len(c.Watches))
+
+	for _, w := range c.Watches {
+		if w.Path != "" {
+			if abs, err := filepath.Abs(filepath.FromSlash(w.Path)); err == nil {
+				w.Path = abs
+			}
+		}
+		if w.BaseFolder == "" {
+			w.BaseFolder = c.Drive.BaseFolder
+		}
+		if w.DailyPrefix == "" {
+			w.DailyPrefix = c.Drive.DailyPrefix
+		}
+		if len(w.Share) == 0 {
+			w.Share = c.Drive.Share
+		}
+		if w.Rules == nil {
+			w.Rules = &c.Rules
+		}
+		// target and targets are merged in one list, the first one is kept on target
+		names := w.Targets
+		if w.Target != "" {
+			names = append([]string{w.Target}, names...)
+		}
+		w.Targets = utils.Unique(names)
+		if len(w.Targets) == 0 {
+			w.Targets = []string{DefaultTarget}
+		}
+		w.Target = w.Targets[0]
+
+		watches = append(watches, w)
+	}
+
+	return watches
+}
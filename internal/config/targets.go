@@ -0,0 +1,277 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// name of the google drive target built from the top level credentials and drive section,
+// used by the watch entries without target
+const DefaultTarget = "gdrive"
+
+// known target types
+const (
+	TargetGDrive = "gdrive"
+	TargetS3     = "s3"
+	TargetWebDAV = "webdav"
+	TargetLocal  = "local"
+	TargetSFTP   = "sftp"
+)
+
+// TargetConfig is one storage backend, only the section of its type is used
+type TargetConfig struct {
+	Type   string              `yaml:"type"`
+	GDrive *GDriveTargetConfig `yaml:"gdrive"`
+	S3     *S3TargetConfig     `yaml:"s3"`
+	WebDAV *WebDAVTargetConfig `yaml:"webdav"`
+	Local  *LocalTargetConfig  `yaml:"local"`
+	SFTP   *SFTPTargetConfig   `yaml:"sftp"`
+}
+
+type GDriveTargetConfig struct {
+	// empty use the top level credentials
+	Credentials string `yaml:"credentials"`
+	// empty use the top level oauth when credentials is empty too
+	OAuth *OAuthConfig `yaml:"oauth"`
+}
+
+// OAuthConfig is the login with a google account, see 'ss-watcher auth login'
+type OAuthConfig struct {
+	// oauth client JSON of a "Desktop app" client from the google cloud console
+	ClientSecret string `yaml:"client_secret"`
+	// where the token is saved, default DefaultTokenPath
+	Token string `yaml:"token"`
+}
+
+// DefaultTokenPath is <user config dir>/ss-watcher/token-<target>.json
+func DefaultTokenPath(target string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "ss-watcher", "token-"+target+".json")
+}
+
+// UseOAuth report whether the drive target log in with a google account instead of the service account
+func (t TargetConfig) UseOAuth() bool {
+	return t.Type == TargetGDrive && t.GDrive != nil && t.GDrive.OAuth != nil && t.GDrive.OAuth.ClientSecret != ""
+}
+
+type S3TargetConfig struct {
+	Endpoint     string `yaml:"endpoint"`
+	Region       string `yaml:"region"`
+	Bucket       string `yaml:"bucket"`
+	Prefix       string `yaml:"prefix"`
+	AccessKey    string `yaml:"access_key"`
+	SecretKey    string `yaml:"secret_key"`
+	SessionToken string `yaml:"session_token"`
+	Insecure     bool   `yaml:"insecure"`
+	PathStyle    bool   `yaml:"path_style"`
+	// placeholders: {prefix} {yyyy} {mm} {dd} {date}
+	DailyTemplate string        `yaml:"daily_template"`
+	SSE           string        `yaml:"sse"`
+	SSEKMSKeyID   string        `yaml:"sse_kms_key_id"`
+	LinkExpiry    time.Duration `yaml:"link_expiry"`
+}
+
+type WebDAVTargetConfig struct {
+	// base url of the user files, ex: https://cloud.example.com/remote.php/dav/files/alice/
+	URL      string        `yaml:"url"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type LocalTargetConfig struct {
+	// destination folder (second disk, mounted NAS share), must already exist
+	Path string `yaml:"path"`
+}
+
+type SFTPTargetConfig struct {
+	// host[:port], default port 22
+	Host string `yaml:"host"`
+	User string `yaml:"user"`
+	// private key file, empty use the ssh agent
+	KeyPath       string `yaml:"key_path"`
+	KeyPassphrase string `yaml:"key_passphrase"`
+	// default ~/.ssh/known_hosts, unknown host key is always refused
+	KnownHosts string `yaml:"known_hosts"`
+	// remote folder where the base folders are created, must already exist
+	Root    string        `yaml:"root"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Target return the config of the target name, the default one is built from the top level values when not configured
+func (c *Config) Target(name string) (TargetConfig, error) {
+	t, ok := c.Targets[name]
+	if !ok && name != DefaultTarget {
+		return TargetConfig{}, fmt.Errorf("target '%s' is not defined on targets", name)
+	}
+	if !ok {
+		t = TargetConfig{Type: TargetGDrive}
+	}
+
+	if t.Type == TargetGDrive {
+		// copy, so the config itself is never changed
+		gd := GDriveTargetConfig{}
+		if t.GDrive != nil {
+			gd = *t.GDrive
+		}
+		if gd.OAuth == nil && gd.Credentials == "" {
+			gd.OAuth = c.OAuth
+		}
+		if gd.OAuth != nil && gd.OAuth.ClientSecret != "" {
+			oauth := *gd.OAuth
+			if oauth.Token == "" {
+				oauth.Token = DefaultTokenPath(name)
+			}
+			gd.OAuth = &oauth
+		} else if gd.Credentials == "" {
+			gd.Credentials = c.Credentials
+		}
+		t.GDrive = &gd
+	}
+
+	return t, nil
+}
+
+// UsedTargets return the sorted target names used by the watch entries, the default one when there is no watch
+func (c *Config) UsedTargets() []string {
+	seen := make(map[string]bool)
+	for _, w := range c.ResolvedWatches() {
+		for _, name := range w.Targets {
+			seen[name] = true
+		}
+	}
+
+	if len(seen) == 0 {
+		seen[DefaultTarget] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// validateTargets check every used target, unused one only need a known type
+func (c *Config) validateTargets() []string {
+	var problems []string
+
+	for name, t := range c.Targets {
+		if t.Type != TargetGDrive && t.Type != TargetS3 && t.Type != TargetWebDAV && t.Type != TargetLocal && t.Type != TargetSFTP {
+			problems = append(problems, fmt.Sprintf("targets.%s.type: unknown type '%s'", name, t.Type))
+		}
+	}
+
+	for _, name := range c.UsedTargets() {
+		t, err := c.Target(name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		key := "targets." + name
+		switch t.Type {
+		case TargetGDrive:
+			if t.UseOAuth() {
+				if _, err := os.Stat(t.GDrive.OAuth.ClientSecret); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: oauth.client_secret: %v", key, err))
+				}
+			} else if t.GDrive.Credentials == "" {
+				problems = append(problems, key+": credentials: service account JSON path is empty")
+			} else if _, err := os.Stat(t.GDrive.Credentials); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: credentials: %v", key, err))
+			}
+
+		case TargetS3:
+			if t.S3 == nil || t.S3.Bucket == "" {
+				problems = append(problems, key+".s3.bucket: is empty")
+				continue
+			}
+			if t.S3.SSE != "" && t.S3.SSE != "AES256" && t.S3.SSE != "aws:kms" {
+				problems = append(problems, fmt.Sprintf("%s.s3.sse: unknown value '%s', use AES256 or aws:kms", key, t.S3.SSE))
+			}
+			if t.S3.SSE == "aws:kms" && t.S3.SSEKMSKeyID == "" {
+				problems = append(problems, key+".s3.sse_kms_key_id: is empty")
+			}
+			if (t.S3.AccessKey == "") != (t.S3.SecretKey == "") {
+				problems = append(problems, key+".s3: access_key and secret_key must be set together")
+			}
+
+		case TargetWebDAV:
+			if t.WebDAV == nil || t.WebDAV.URL == "" {
+				problems = append(problems, key+".webdav.url: is empty")
+				continue
+			}
+			if u, err := url.Parse(t.WebDAV.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("%s.webdav.url: '%s' is not a http(s) url", key, t.WebDAV.URL))
+			}
+			if t.WebDAV.Password != "" && t.WebDAV.Username == "" {
+				problems = append(problems, key+".webdav.username: is empty but password is set")
+			}
+
+		case TargetLocal:
+			if t.Local == nil || t.Local.Path == "" {
+				problems = append(problems, key+".local.path: is empty")
+				continue
+			}
+			if info, err := os.Stat(t.Local.Path); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.local.path: %v", key, err))
+			} else if !info.IsDir() {
+				problems = append(problems, fmt.Sprintf("%s.local.path: '%s' is not a directory", key, t.Local.Path))
+			}
+
+			// the copy would be picked up again by the watcher
+			dest, _ := filepath.Abs(t.Local.Path)
+			for _, w := range c.ResolvedWatches() {
+				if isSubPath(w.Path, dest) || isSubPath(dest, w.Path) {
+					problems = append(problems, fmt.Sprintf("%s.local.path: '%s' overlap the watch path '%s'", key, dest, w.Path))
+				}
+			}
+
+		case TargetSFTP:
+			if t.SFTP == nil {
+				problems = append(problems, key+".sftp: is empty")
+				continue
+			}
+			if t.SFTP.Host == "" {
+				problems = append(problems, key+".sftp.host: is empty")
+			}
+			if t.SFTP.User == "" {
+				problems = append(problems, key+".sftp.user: is empty")
+			}
+			if t.SFTP.Root == "" {
+				problems = append(problems, key+".sftp.root: is empty")
+			}
+			if t.SFTP.KeyPath != "" {
+				if _, err := os.Stat(t.SFTP.KeyPath); err != nil {
+					problems = append(problems, fmt.Sprintf("%s.sftp.key_path: %v", key, err))
+				}
+			} else if os.Getenv("SSH_AUTH_SOCK") == "" {
+				problems = append(problems, key+".sftp.key_path: is empty and no ssh agent is running")
+			}
+			if t.SFTP.KnownHosts != "" {
+				if _, err := os.Stat(t.SFTP.KnownHosts); err != nil {
+					problems = append(problems, fmt.Sprintf("%s.sftp.known_hosts: %v", key, err))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// isSubPath report whether p is the parent itself or inside it
+func isSubPath(parent, p string) bool {
+	rel, err := filepath.Rel(parent, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -3,20 +3,15 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
 
 	_ "modernc.org/sqlite"
 )
 
-func InitDB() *sql.DB {
-	var err error
-
-	DB, err := sql.Open("sqlite", "./internal/database/migrations/database.db")
-	if err != nil {
-		log.Fatal("Connect DB Sqlite Error: ", err)
-	}
-
-	query := `
+// migrations are applied in order and only once, the index+1 of the last applied one is stored on PRAGMA user_version.
+// never edit or reorder applied migration, always append new one
+var migrations = []string{
+	// 1: initial tables
+	`
 		CREATE TABLE IF NOT EXISTS records (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			item_id TEXT NOT NULL,
@@ -30,17 +25,151 @@ func InitDB() *sql.DB {
 			permission_id TEXT NOT NULL,
 			email TEXT NOT NULL
 		);
+	`,
+	// 2: watched folder of the record, so the same filename on different folders does not clash
+	`
+		ALTER TABLE records ADD COLUMN watch TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_records_watch_name ON records (watch, name);
+	`,
+	// 3: path relative to the watched folder, older records are all on the top level so the path is the name
+	`
+		ALTER TABLE records ADD COLUMN path TEXT NOT NULL DEFAULT '';
+		UPDATE records SET path = name WHERE path = '';
+		CREATE INDEX IF NOT EXISTS idx_records_watch_path ON records (watch, path);
+	`,
+	// 4: detected mime type of the uploaded file, older uploads were all sent as image/png
+	`
+		ALTER TABLE records ADD COLUMN mime_type TEXT NOT NULL DEFAULT 'image/png';
+	`,
+	// 5: one row per (file, target) with its own status, so a failed target is retried without uploading to the others again.
+	// older records have empty target and were all uploaded
+	`
+		ALTER TABLE records ADD COLUMN target TEXT NOT NULL DEFAULT '';
+		ALTER TABLE records ADD COLUMN status TEXT NOT NULL DEFAULT 'uploaded';
+		ALTER TABLE records ADD COLUMN error TEXT NOT NULL DEFAULT '';
+		ALTER TABLE records ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1;
+		CREATE INDEX IF NOT EXISTS idx_records_watch_path_target ON records (watch, path, target);
+		CREATE INDEX IF NOT EXISTS idx_records_status ON records (status);
+	`,
+	// 6: resumable upload sessions, so a big file continue from the last chunk after a failure or restart
+	`
+		CREATE TABLE IF NOT EXISTS upload_sessions (
+			session_key TEXT PRIMARY KEY,
+			uri TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			mod_time INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+	`,
+	// 7: queue of the uploads and deletes, so the work is not lost when offline or restarted.
+	// the failed uploads of the older version are moved to the queue
 	`
+		CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			watch TEXT NOT NULL,
+			path TEXT NOT NULL,
+			target TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			run_at INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_jobs_run_at ON jobs (run_at);
+		CREATE INDEX IF NOT EXISTS idx_jobs_watch_path_target ON jobs (watch, path, target);
 
-	if _, err := DB.Exec(query); err != nil {
-		log.Fatal("Create Table Error: ", err)
+		INSERT INTO jobs (kind, watch, path, target, attempts, error, run_at, created_at)
+		SELECT 'upload', watch, path, target, attempts, error, 0, CAST(strftime('%s', 'now') AS INTEGER) FROM records WHERE status = 'failed';
+	`,
+	// 8: old path of the move job, the file is renamed on the storage instead of uploaded again
+	`
+		ALTER TABLE jobs ADD COLUMN from_path TEXT NOT NULL DEFAULT '';
+	`,
+	// 9: size and hashes of the uploaded content, so unchanged and duplicate files are not uploaded again.
+	// duplicate_of is the record id of the upload that a duplicate use
+	`
+		ALTER TABLE records ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE records ADD COLUMN md5 TEXT NOT NULL DEFAULT '';
+		ALTER TABLE records ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+		ALTER TABLE records ADD COLUMN duplicate_of INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS idx_records_target_sha256 ON records (target, sha256);
+		CREATE INDEX IF NOT EXISTS idx_records_duplicate_of ON records (duplicate_of);
+	`,
+	// 10: perceptual hash and capture time of the screenshots, similar_to is the record id of the first screenshot
+	// of the near duplicates
+	`
+		ALTER TABLE records ADD COLUMN phash TEXT NOT NULL DEFAULT '';
+		ALTER TABLE records ADD COLUMN mod_time INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE records ADD COLUMN similar_to INTEGER NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS idx_records_target_mod_time ON records (target, mod_time);
+	`,
+	// 11: folder of the resumable upload session, the session is found by the local file so it continue after the
+	// daily folder changed
+	`
+		ALTER TABLE upload_sessions ADD COLUMN folder_id TEXT NOT NULL DEFAULT '';
+	`,
+	// 12: unix seconds the job was started by a worker, 0 when waiting. an edit during the upload queue a new job
+	`
+		ALTER TABLE jobs ADD COLUMN started_at INTEGER NOT NULL DEFAULT 0;
+	`,
+}
+
+func InitDB(path string) (*sql.DB, error) {
+	if path == "" {
+		return nil, fmt.Errorf("Connect DB Sqlite Error: database path is empty")
+	}
+
+	DB, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("Connect DB Sqlite Error: %v", err)
 	}
 
+	// the watcher queue worker and the event loop share the file, one connection avoid "database is locked".
+	// the transactions are short and never wait on the storage, so the workers still upload at the same time
+	DB.SetMaxOpenConns(1)
+
 	if err = DB.Ping(); err != nil {
-		log.Fatal("Ping DB Sqlite Error: ", err)
+		DB.Close()
+		return nil, fmt.Errorf("Ping DB Sqlite Error: %v", err)
+	}
+
+	if err := migrate(DB); err != nil {
+		DB.Close()
+		return nil, fmt.Errorf("Migrate DB Error: %v", err)
 	}
 
 	fmt.Println("Connected to DB Sqlite")
 
-	return DB
+	return DB, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+
+		// pragma does not support placeholder
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+	}
+
+	return nil
 }
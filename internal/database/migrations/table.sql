@@ -1,13 +1,63 @@
+-- reference of the current schema, the tables are created and migrated by database.InitDB
+
 CREATE TABLE IF NOT EXISTS records (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     item_id TEXT NOT NULL,
     name TEXT NOT NULL,
     folder_id TEXT NOT NULL,
-    date DATE NOT NULL
+    date DATE NOT NULL,
+    watch TEXT NOT NULL DEFAULT '',
+    path TEXT NOT NULL DEFAULT '',
+    mime_type TEXT NOT NULL DEFAULT 'image/png',
+    target TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'uploaded',
+    error TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 1,
+    size INTEGER NOT NULL DEFAULT 0,
+    md5 TEXT NOT NULL DEFAULT '',
+    sha256 TEXT NOT NULL DEFAULT '',
+    duplicate_of INTEGER NOT NULL DEFAULT 0,
+    phash TEXT NOT NULL DEFAULT '',
+    mod_time INTEGER NOT NULL DEFAULT 0,
+    similar_to INTEGER NOT NULL DEFAULT 0
 );
 
+CREATE INDEX IF NOT EXISTS idx_records_watch_name ON records (watch, name);
+CREATE INDEX IF NOT EXISTS idx_records_watch_path ON records (watch, path);
+CREATE INDEX IF NOT EXISTS idx_records_watch_path_target ON records (watch, path, target);
+CREATE INDEX IF NOT EXISTS idx_records_status ON records (status);
+CREATE INDEX IF NOT EXISTS idx_records_target_sha256 ON records (target, sha256);
+CREATE INDEX IF NOT EXISTS idx_records_duplicate_of ON records (duplicate_of);
+CREATE INDEX IF NOT EXISTS idx_records_target_mod_time ON records (target, mod_time);
+
 CREATE TABLE IF NOT EXISTS user_permission (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     permission_id TEXT NOT NULL,
-    email TEXT NOT NULL,
-)
\ No newline at end of file
+    email TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    session_key TEXT PRIMARY KEY,
+    uri TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    mod_time INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    folder_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    watch TEXT NOT NULL,
+    path TEXT NOT NULL,
+    target TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    run_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    from_path TEXT NOT NULL DEFAULT '',
+    started_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_run_at ON jobs (run_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_watch_path_target ON jobs (watch, path, target);
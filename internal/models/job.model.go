@@ -0,0 +1,32 @@
+package models
+
+// kind of the job on the queue
+const (
+	JobUpload = "upload"
+	JobDelete = "delete"
+	// rename or move of an uploaded file, from FromPath to Path
+	JobMove = "move"
+	// upload that replace the file on the storage even when its record already has the local content, queued by
+	// the repair of a corrupted file
+	JobReupload = "reupload"
+)
+
+// Job is one pending upload, delete or move of a file on one target, removed from the queue once done
+type Job struct {
+	ID    int    `json:"id"`
+	Kind  string `json:"kind"`
+	Watch string `json:"watch"`
+	// slash separated path relative to the watched folder
+	Path string `json:"path"`
+	// old path of the move job, empty for the other kinds
+	FromPath string `json:"from_path"`
+	Target   string `json:"target"`
+	Attempts int    `json:"attempts"`
+	// error of the last failed attempt
+	Error string `json:"error"`
+	// unix seconds, the job is not run before this time
+	RunAt     int64 `json:"run_at"`
+	CreatedAt int64 `json:"created_at"`
+	// unix seconds the worker started the job, 0 while it wait
+	StartedAt int64 `json:"started_at"`
+}
@@ -1,9 +1,42 @@
 package models
 
+// status of the record on its target
+const (
+	RecordUploaded = "uploaded"
+	// upload failed, retried by the watcher, item id is empty
+	RecordFailed = "failed"
+	// permanent error (no permission, bad request, quota), not retried until the file is uploaded again
+	RecordRejected = "rejected"
+	// same content as an other uploaded file of the target (duplicate_of), not uploaded again.
+	// item id is the shortcut to the uploaded file, empty when no shortcut was created
+	RecordDuplicate = "duplicate"
+	// near duplicate of an other screenshot (similar_to) skipped by the similar policy, not uploaded
+	RecordSimilar = "similar"
+)
+
 type Records struct {
 	ID       int    `json:"id"`
 	ItemID   string `json:"item_id"`
 	Name     string `json:"name"`
 	FolderID string `json:"folder_id"`
 	Date     string `json:"date"`
+	Watch    string `json:"watch"`
+	// slash separated path relative to the watched folder
+	Path     string `json:"path"`
+	MimeType string `json:"mime_type"`
+	// name of the storage target, empty for records created before multiple target support
+	Target   string `json:"target"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+	// size and hex hashes of the uploaded content, empty for records created before hashing
+	Size        int64  `json:"size"`
+	MD5         string `json:"md5"`
+	SHA256      string `json:"sha256"`
+	DuplicateOf int    `json:"duplicate_of"`
+	// hex dhash of the image, empty when it is not an image or the similar detection is off
+	PHash string `json:"phash"`
+	// unix time of the local file modification, the capture time of the screenshot
+	ModTime   int64 `json:"mod_time"`
+	SimilarTo int   `json:"similar_to"`
 }
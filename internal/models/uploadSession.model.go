@@ -0,0 +1,13 @@
+package models
+
+type UploadSession struct {
+	SessionKey string `json:"session_key"`
+	URI        string `json:"uri"`
+	Size       int64  `json:"size"`
+	// unix nano of the local file mtime, compared exactly
+	ModTime int64 `json:"mod_time"`
+	// unix seconds
+	CreatedAt int64 `json:"created_at"`
+	// folder of the new file given when the session started, empty for the session of a new revision
+	FolderID string `json:"folder_id"`
+}
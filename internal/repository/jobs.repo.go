@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/momokii/ss-watcher/internal/models"
+)
+
+type JobRepository interface {
+	FindAll(tx *sql.Tx) (*[]models.Job, error)
+	FindByKind(tx *sql.Tx, kind, watch, target string) (*[]models.Job, error)
+	FindDue(tx *sql.Tx, now int64, skipTargets []string, only []int, limit int) (*[]models.Job, error)
+	NextRunAt(tx *sql.Tx, after int64) (int64, bool, error)
+	Enqueue(tx *sql.Tx, job *models.Job) error
+	Start(tx *sql.Tx, id int, now int64) (bool, error)
+	Release(tx *sql.Tx, id int) error
+	Reschedule(tx *sql.Tx, job *models.Job) error
+	RunAllAt(tx *sql.Tx, runAt, holdSince int64) error
+	ResetStarted(tx *sql.Tx) error
+	Delete(tx *sql.Tx, id int) error
+}
+
+type jobRepository struct{}
+
+func NewJobsRepository() JobRepository {
+	return &jobRepository{}
+}
+
+const jobColumns = "id, kind, watch, path, from_path, target, attempts, error, run_at, created_at, started_at"
+
+func (r *jobRepository) findMany(tx *sql.Tx, query string, args ...any) (*[]models.Job, error) {
+
+	var jobs []models.Job
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job models.Job
+
+		if err := rows.Scan(&job.ID, &job.Kind, &job.Watch, &job.Path, &job.FromPath, &job.Target, &job.Attempts, &job.Error, &job.RunAt, &job.CreatedAt, &job.StartedAt); err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return &jobs, rows.Err()
+}
+
+func (r *jobRepository) FindAll(tx *sql.Tx) (*[]models.Job, error) {
+	return r.findMany(tx, "SELECT "+jobColumns+" FROM jobs ORDER BY id")
+}
+
+// FindByKind return the queued jobs of the kind for the watch and target, oldest first
+func (r *jobRepository) FindByKind(tx *sql.Tx, kind, watch, target string) (*[]models.Job, error) {
+	return r.findMany(tx, "SELECT "+jobColumns+" FROM jobs WHERE kind = ? AND watch = ? AND target = ? ORDER BY id", kind, watch, target)
+}
+
+// FindDue return the jobs that can run now, oldest first, without the jobs of skipTargets and with only the ids of
+// only when it is not nil. a job wait for the older jobs of the same file and target, so a remove never run before
+// the upload it follow. a move wait for the jobs of both its paths
+func (r *jobRepository) FindDue(tx *sql.Tx, now int64, skipTargets []string, only []int, limit int) (*[]models.Job, error) {
+	args := []any{now}
+
+	skip := ""
+	if len(skipTargets) > 0 {
+		skip = " AND target NOT IN (?" + strings.Repeat(", ?", len(skipTargets)-1) + ")"
+		for _, target := range skipTargets {
+			args = append(args, target)
+		}
+	}
+	if only != nil {
+		// the NULL keep the list valid sql when only is empty, it never match
+		skip += " AND id IN (NULL" + strings.Repeat(", ?", len(only)) + ")"
+		for _, id := range only {
+			args = append(args, id)
+		}
+	}
+	args = append(args, limit)
+
+	return r.findMany(tx, `
+		SELECT `+jobColumns+` FROM jobs j
+		WHERE run_at <= ?`+skip+`
+		AND NOT EXISTS (
+			SELECT 1 FROM jobs o WHERE o.watch = j.watch AND o.target = j.target AND o.id < j.id
+			AND (o.path IN (j.path, j.from_path) OR (o.from_path != '' AND o.from_path IN (j.path, j.from_path)))
+		)
+		ORDER BY id LIMIT ?`, args...)
+}
+
+// NextRunAt return the earliest run time later than after, false when there is none
+func (r *jobRepository) NextRunAt(tx *sql.Tx, after int64) (int64, bool, error) {
+
+	var runAt sql.NullInt64
+
+	if err := tx.QueryRow("SELECT MIN(run_at) FROM jobs WHERE run_at > ?", after).Scan(&runAt); err != nil {
+		return 0, false, err
+	}
+
+	return runAt.Int64, runAt.Valid, nil
+}
+
+// Enqueue add the job and set its id, nothing is added when the last job of the same file and target is the same
+// and not started yet, the id is the one of that job. a started job may have read the file already, so an edit
+// during the upload get its own job
+func (r *jobRepository) Enqueue(tx *sql.Tx, job *models.Job) error {
+
+	var lastID int
+	var lastKind, lastFrom string
+	var lastStarted int64
+
+	err := tx.QueryRow("SELECT id, kind, from_path, started_at FROM jobs WHERE watch = ? AND path = ? AND target = ? ORDER BY id DESC LIMIT 1", job.Watch, job.Path, job.Target).Scan(&lastID, &lastKind, &lastFrom, &lastStarted)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && lastStarted == 0 && lastKind == job.Kind && lastFrom == job.FromPath {
+		job.ID = lastID
+		return nil
+	}
+
+	res, err := tx.Exec("INSERT INTO jobs (kind, watch, path, from_path, target, attempts, error, run_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", job.Kind, job.Watch, job.Path, job.FromPath, job.Target, job.Attempts, job.Error, job.RunAt, job.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	job.ID = int(id)
+
+	return nil
+}
+
+// Start mark the waiting job as started, false when it is already started or gone
+func (r *jobRepository) Start(tx *sql.Tx, id int, now int64) (bool, error) {
+
+	res, err := tx.Exec("UPDATE jobs SET started_at = ? WHERE id = ? AND started_at = 0", now, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Release make the started job wait again without counting an attempt
+func (r *jobRepository) Release(tx *sql.Tx, id int) error {
+
+	if _, err := tx.Exec("UPDATE jobs SET started_at = 0 WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Reschedule store the attempts, error and next run time of the failed job, it wait again
+func (r *jobRepository) Reschedule(tx *sql.Tx, job *models.Job) error {
+
+	if _, err := tx.Exec("UPDATE jobs SET attempts = ?, error = ?, run_at = ?, started_at = 0 WHERE id = ?", job.Attempts, job.Error, job.RunAt, job.ID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RunAllAt move every job scheduled later than runAt to runAt. the delete jobs created after holdSince are still
+// held for the Create event of a move, they keep their run time
+func (r *jobRepository) RunAllAt(tx *sql.Tx, runAt, holdSince int64) error {
+
+	if _, err := tx.Exec("UPDATE jobs SET run_at = ? WHERE run_at > ? AND NOT (kind = ? AND created_at > ?)", runAt, runAt, models.JobDelete, holdSince); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResetStarted make the jobs started by a stopped process wait again
+func (r *jobRepository) ResetStarted(tx *sql.Tx) error {
+
+	if _, err := tx.Exec("UPDATE jobs SET started_at = 0 WHERE started_at != 0"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *jobRepository) Delete(tx *sql.Tx, id int) error {
+
+	if _, err := tx.Exec("DELETE FROM jobs WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -7,9 +7,16 @@ import (
 )
 
 type RecordRepository interface {
-	FindByName(tx *sql.Tx, filename string) (*models.Records, error)
+	FindAll(tx *sql.Tx) (*[]models.Records, error)
+	FindByPath(tx *sql.Tx, watch, path, target string) (*models.Records, error)
+	FindByStatus(tx *sql.Tx, status string) (*[]models.Records, error)
+	FindByHash(tx *sql.Tx, target, sha256 string) (*models.Records, error)
+	FindDuplicates(tx *sql.Tx, id int) (*[]models.Records, error)
+	FindByModTime(tx *sql.Tx, target string, from, to int64) (*[]models.Records, error)
+	Relink(tx *sql.Tx, from, to int) error
 	Create(tx *sql.Tx, record *models.Records) error
-	Delete(tx *sql.Tx, id string) error
+	Update(tx *sql.Tx, record *models.Records) error
+	Delete(tx *sql.Tx, id int) error
 }
 
 type recordRepository struct{}
@@ -18,11 +25,45 @@ func NewRecordsRepository() RecordRepository {
 	return &recordRepository{}
 }
 
-func (r *recordRepository) FindByName(tx *sql.Tx, filename string) (*models.Records, error) {
+const recordColumns = "id, item_id, name, folder_id, date, watch, path, mime_type, target, status, error, attempts, size, md5, sha256, duplicate_of, phash, mod_time, similar_to"
+
+func scanRecord(row interface{ Scan(...any) error }, record *models.Records) error {
+	return row.Scan(&record.ID, &record.ItemID, &record.Name, &record.FolderID, &record.Date, &record.Watch, &record.Path, &record.MimeType, &record.Target, &record.Status, &record.Error, &record.Attempts, &record.Size, &record.MD5, &record.SHA256, &record.DuplicateOf, &record.PHash, &record.ModTime, &record.SimilarTo)
+}
+
+func (r *recordRepository) findMany(tx *sql.Tx, query string, args ...any) (*[]models.Records, error) {
+
+	var records []models.Records
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record models.Records
+
+		if err := scanRecord(rows, &record); err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return &records, rows.Err()
+}
+
+func (r *recordRepository) FindAll(tx *sql.Tx) (*[]models.Records, error) {
+	return r.findMany(tx, "SELECT "+recordColumns+" FROM records ORDER BY id")
+}
+
+func (r *recordRepository) FindByPath(tx *sql.Tx, watch, path, target string) (*models.Records, error) {
 
 	record := &models.Records{}
 
-	err := tx.QueryRow("SELECT id, item_id, name, folder_id, date FROM records WHERE name = ?", filename).Scan(&record.ID, &record.ItemID, &record.Name, &record.FolderID, &record.Date)
+	// records created before multiple watch/target support have empty watch/target, still match them but prefer the exact one
+	err := scanRecord(tx.QueryRow("SELECT "+recordColumns+" FROM records WHERE path = ? AND watch IN (?, '') AND target IN (?, '') ORDER BY watch DESC, target DESC, id DESC LIMIT 1", path, watch, target), record)
 	if err != nil {
 		return nil, err
 	}
@@ -30,18 +71,64 @@ func (r *recordRepository) FindByName(tx *sql.Tx, filename string) (*models.Reco
 	return record, nil
 }
 
+func (r *recordRepository) FindByStatus(tx *sql.Tx, status string) (*[]models.Records, error) {
+	return r.findMany(tx, "SELECT "+recordColumns+" FROM records WHERE status = ? ORDER BY id", status)
+}
+
+// FindByHash return the first uploaded record of the target with the content, sql.ErrNoRows when there is none
+func (r *recordRepository) FindByHash(tx *sql.Tx, target, sha256 string) (*models.Records, error) {
+
+	record := &models.Records{}
+
+	err := scanRecord(tx.QueryRow("SELECT "+recordColumns+" FROM records WHERE target = ? AND sha256 = ? AND status = ? ORDER BY id LIMIT 1", target, sha256, models.RecordUploaded), record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// FindDuplicates return the duplicate records that use the upload of the record id
+func (r *recordRepository) FindDuplicates(tx *sql.Tx, id int) (*[]models.Records, error) {
+	return r.findMany(tx, "SELECT "+recordColumns+" FROM records WHERE duplicate_of = ? ORDER BY id", id)
+}
+
+// FindByModTime return the uploaded or skipped screenshots of the target with an image hash, taken between from and to
+func (r *recordRepository) FindByModTime(tx *sql.Tx, target string, from, to int64) (*[]models.Records, error) {
+	return r.findMany(tx, "SELECT "+recordColumns+" FROM records WHERE target = ? AND mod_time BETWEEN ? AND ? AND phash != '' AND status IN (?, ?) ORDER BY mod_time, id", target, from, to, models.RecordUploaded, models.RecordSimilar)
+}
+
+// Relink point the duplicates of the record from to the record to
+func (r *recordRepository) Relink(tx *sql.Tx, from, to int) error {
+
+	if _, err := tx.Exec("UPDATE records SET duplicate_of = ? WHERE duplicate_of = ?", to, from); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (r *recordRepository) Create(tx *sql.Tx, record *models.Records) error {
 
-	if _, err := tx.Exec("INSERT INTO records (item_id, name, folder_id, date) VALUES (?, ?, ?, ?)", record.ItemID, record.Name, record.FolderID, record.Date); err != nil {
+	if _, err := tx.Exec("INSERT INTO records (item_id, name, folder_id, date, watch, path, mime_type, target, status, error, attempts, size, md5, sha256, duplicate_of, phash, mod_time, similar_to) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", record.ItemID, record.Name, record.FolderID, record.Date, record.Watch, record.Path, record.MimeType, record.Target, record.Status, record.Error, record.Attempts, record.Size, record.MD5, record.SHA256, record.DuplicateOf, record.PHash, record.ModTime, record.SimilarTo); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *recordRepository) Update(tx *sql.Tx, record *models.Records) error {
+
+	if _, err := tx.Exec("UPDATE records SET item_id = ?, name = ?, folder_id = ?, date = ?, watch = ?, path = ?, mime_type = ?, status = ?, error = ?, attempts = ?, size = ?, md5 = ?, sha256 = ?, duplicate_of = ?, phash = ?, mod_time = ?, similar_to = ? WHERE id = ?", record.ItemID, record.Name, record.FolderID, record.Date, record.Watch, record.Path, record.MimeType, record.Status, record.Error, record.Attempts, record.Size, record.MD5, record.SHA256, record.DuplicateOf, record.PHash, record.ModTime, record.SimilarTo, record.ID); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (r *recordRepository) Delete(tx *sql.Tx, id string) error {
+func (r *recordRepository) Delete(tx *sql.Tx, id int) error {
 
-	if _, err := tx.Exec("DELETE FROM records WHERE item_id = ?", id); err != nil {
+	if _, err := tx.Exec("DELETE FROM records WHERE id = ?", id); err != nil {
 		return err
 	}
 
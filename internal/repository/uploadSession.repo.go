@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/momokii/ss-watcher/internal/models"
+)
+
+type UploadSessionRepository interface {
+	FindByKey(tx *sql.Tx, key string) (*models.UploadSession, error)
+	Save(tx *sql.Tx, session *models.UploadSession) error
+	Delete(tx *sql.Tx, key string) error
+}
+
+type uploadSessionRepository struct{}
+
+func NewUploadSessionRepository() UploadSessionRepository {
+	return &uploadSessionRepository{}
+}
+
+func (r *uploadSessionRepository) FindByKey(tx *sql.Tx, key string) (*models.UploadSession, error) {
+
+	session := &models.UploadSession{}
+
+	err := tx.QueryRow("SELECT session_key, uri, size, mod_time, created_at, folder_id FROM upload_sessions WHERE session_key = ?", key).Scan(&session.SessionKey, &session.URI, &session.Size, &session.ModTime, &session.CreatedAt, &session.FolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Save insert the session or replace the one with the same key
+func (r *uploadSessionRepository) Save(tx *sql.Tx, session *models.UploadSession) error {
+
+	if _, err := tx.Exec("INSERT OR REPLACE INTO upload_sessions (session_key, uri, size, mod_time, created_at, folder_id) VALUES (?, ?, ?, ?, ?, ?)", session.SessionKey, session.URI, session.Size, session.ModTime, session.CreatedAt, session.FolderID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *uploadSessionRepository) Delete(tx *sql.Tx, key string) error {
+
+	if _, err := tx.Exec("DELETE FROM upload_sessions WHERE session_key = ?", key); err != nil {
+		return err
+	}
+
+	return nil
+}
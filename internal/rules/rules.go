@@ -0,0 +1,217 @@
+package rules
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule is one pattern or size filter, Kind is used to explain the result to the user
+type Rule struct {
+	Kind    string
+	Pattern string
+
+	re       *regexp.Regexp
+	baseOnly bool
+}
+
+// Result of Match, Rule is nil when no rule decide the result (allowed by default)
+type Result struct {
+	Allowed bool
+	Rule    *Rule
+	Reason  string
+}
+
+// Rules decide which files get synced, evaluated in this order:
+//  1. exclude glob/regex, first match exclude the file
+//  2. include glob/regex, when there is at least one include the file must match one of them
+//  3. min/max size (0 means no limit)
+type Rules struct {
+	include []*Rule
+	exclude []*Rule
+	minSize int64
+	maxSize int64
+}
+
+// New compile the patterns, glob is matched against the slash separated path relative to the watched folder.
+// glob without slash only match the file name, and ** match any number of folders
+func New(include, exclude, includeRegex, excludeRegex []string, minSize, maxSize int64) (*Rules, error) {
+	r := &Rules{
+		minSize: minSize,
+		maxSize: maxSize,
+	}
+
+	if minSize < 0 || maxSize < 0 {
+		return nil, fmt.Errorf("size limit cannot be negative")
+	}
+	if maxSize > 0 && minSize > maxSize {
+		return nil, fmt.Errorf("min size (%d) is bigger than max size (%d)", minSize, maxSize)
+	}
+
+	for _, p := range include {
+		rule, err := newGlob("include glob", p)
+		if err != nil {
+			return nil, err
+		}
+		r.include = append(r.include, rule)
+	}
+
+	for _, p := range includeRegex {
+		rule, err := newRegex("include regex", p)
+		if err != nil {
+			return nil, err
+		}
+		r.include = append(r.include, rule)
+	}
+
+	for _, p := range exclude {
+		rule, err := newGlob("exclude glob", p)
+		if err != nil {
+			return nil, err
+		}
+		r.exclude = append(r.exclude, rule)
+	}
+
+	for _, p := range excludeRegex {
+		rule, err := newRegex("exclude regex", p)
+		if err != nil {
+			return nil, err
+		}
+		r.exclude = append(r.exclude, rule)
+	}
+
+	return r, nil
+}
+
+// Match check the relative path and the size of the file, size < 0 skip the size filters (ex: file already removed)
+func (r *Rules) Match(rel string, size int64) Result {
+	// nil rules allow everything, so the watcher does not need to check it
+	if r == nil {
+		return Result{Allowed: true, Reason: "no rules"}
+	}
+
+	for _, rule := range r.exclude {
+		if rule.match(rel) {
+			return Result{Allowed: false, Rule: rule, Reason: fmt.Sprintf("excluded by %s '%s'", rule.Kind, rule.Pattern)}
+		}
+	}
+
+	result := Result{Allowed: true, Reason: "no include rules, allowed by default"}
+	if len(r.include) > 0 {
+		result = Result{Allowed: false, Reason: "not matched by any include rule"}
+
+		for _, rule := range r.include {
+			if rule.match(rel) {
+				result = Result{Allowed: true, Rule: rule, Reason: fmt.Sprintf("included by %s '%s'", rule.Kind, rule.Pattern)}
+				break
+			}
+		}
+
+		if !result.Allowed {
+			return result
+		}
+	}
+
+	if size >= 0 {
+		if r.minSize > 0 && size < r.minSize {
+			rule := &Rule{Kind: "min size", Pattern: fmt.Sprint(r.minSize)}
+			return Result{Allowed: false, Rule: rule, Reason: fmt.Sprintf("smaller than min size (%d < %d bytes)", size, r.minSize)}
+		}
+		if r.maxSize > 0 && size > r.maxSize {
+			rule := &Rule{Kind: "max size", Pattern: fmt.Sprint(r.maxSize)}
+			return Result{Allowed: false, Rule: rule, Reason: fmt.Sprintf("bigger than max size (%d > %d bytes)", size, r.maxSize)}
+		}
+	}
+
+	return result
+}
+
+func (rule *Rule) match(rel string) bool {
+	if rule.baseOnly {
+		return rule.re.MatchString(path.Base(rel))
+	}
+	return rule.re.MatchString(rel)
+}
+
+func newRegex(kind, pattern string) (*Rule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s '%s': %v", kind, pattern, err)
+	}
+
+	return &Rule{Kind: kind, Pattern: pattern, re: re}, nil
+}
+
+func newGlob(kind, pattern string) (*Rule, error) {
+	expr, err := globToRegex(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s '%s': %v", kind, pattern, err)
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s '%s': %v", kind, pattern, err)
+	}
+
+	return &Rule{
+		Kind:     kind,
+		Pattern:  pattern,
+		re:       re,
+		baseOnly: !strings.Contains(pattern, "/"),
+	}, nil
+}
+
+// globToRegex convert glob to anchored regex: ** any folders, * and ? anything except slash, [...] character class
+func globToRegex(glob string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	// leading slash only anchor the pattern to the watched folder
+	glob = strings.TrimPrefix(glob, "/")
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				// "**/" match zero or more folders, "**" at the end match everything
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed '['")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				// like * and ? the negated class never match the folder separator
+				class = "^/" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 == len(glob) {
+				return "", fmt.Errorf("nothing to escape after the last '\\'")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String(), nil
+}
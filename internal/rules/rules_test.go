@@ -0,0 +1,164 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		// without slash only the file name is matched, in any folder
+		{"*.png", "a.png", true},
+		{"*.png", "2024/03/a.png", true},
+		{"*.png", "a.png.tmp", false},
+		{"a?.png", "ab.png", true},
+		{"a?.png", "abc.png", false},
+		{"SS_*", "SS_2024/a.png", false},
+
+		// with slash the whole relative path, * and ? stay inside one folder
+		{"shots/*.png", "shots/a.png", true},
+		{"shots/*.png", "shots/2024/a.png", false},
+		{"shots/*.png", "other/shots/a.png", false},
+		{"shots/?/a.png", "shots/x/a.png", true},
+		{"shots/?/a.png", "shots//a.png", false},
+		{"a*b/c.png", "a/x/b/c.png", false},
+
+		// "**/" is zero or more folders
+		{"**/*.png", "a.png", true},
+		{"**/*.png", "x/y/z/a.png", true},
+		{"shots/**/a.png", "shots/a.png", true},
+		{"shots/**/a.png", "shots/x/y/a.png", true},
+		{"shots/**/a.png", "shots/xa.png", false},
+
+		// trailing "**" is everything inside the folder
+		{"tmp/**", "tmp/a.png", true},
+		{"tmp/**", "tmp/x/y/a.png", true},
+		{"tmp/**", "tmp", false},
+		{"tmp/**", "tmpx/a.png", false},
+
+		// leading slash anchor to the watched folder
+		{"/a.png", "a.png", true},
+		{"/a.png", "x/a.png", false},
+		{"/shots/*.png", "shots/a.png", true},
+		{"/shots/*.png", "x/shots/a.png", false},
+
+		// classes
+		{"img[0-9].png", "img5.png", true},
+		{"img[0-9].png", "imgx.png", false},
+		{"img[!0-9].png", "imgx.png", true},
+		{"img[!0-9].png", "img5.png", false},
+		{"shots/a[!x]b.png", "shots/a/b.png", false},
+		{"shots/a[!x]b.png", "shots/ayb.png", true},
+
+		// escapes and regex characters are literal
+		{`\*.png`, "*.png", true},
+		{`\*.png`, "a.png", false},
+		{`a\?.png`, "a?.png", true},
+		{`a\?.png`, "ab.png", false},
+		{`\[1\].png`, "[1].png", true},
+		{"a+b (1).png", "a+b (1).png", true},
+		{"a.png", "axpng", false},
+		{"^a$.png", "^a$.png", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			rule, err := newGlob("include glob", tt.glob)
+			if err != nil {
+				t.Fatalf("newGlob(%q) error: %v", tt.glob, err)
+			}
+			if got := rule.match(tt.path); got != tt.want {
+				t.Fatalf("glob %q match %q = %v, want %v (regex %s)", tt.glob, tt.path, got, tt.want, rule.re)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		incRe   []string
+		excRe   []string
+		min     int64
+		max     int64
+		path    string
+		size    int64
+		want    bool
+		// part of the reason
+		reason string
+	}{
+		{name: "no rules", path: "a.png", size: 10, want: true, reason: "allowed by default"},
+		{name: "included", include: []string{"*.png"}, path: "a.png", size: 10, want: true, reason: "included by include glob '*.png'"},
+		{name: "not included", include: []string{"*.png"}, path: "a.txt", size: 10, want: false, reason: "not matched by any include rule"},
+		{name: "include regex", incRe: []string{`^shots/.*\.jpe?g$`}, path: "shots/a.jpeg", size: 10, want: true, reason: "include regex"},
+		{name: "excluded", exclude: []string{"*.tmp"}, path: "a.tmp", size: 10, want: false, reason: "excluded by exclude glob '*.tmp'"},
+		{name: "exclude regex", excRe: []string{`~$`}, path: "a.png~", size: 10, want: false, reason: "exclude regex"},
+		// exclude is checked before include
+		{name: "exclude first", include: []string{"*.png"}, exclude: []string{"private/**"}, path: "private/a.png", size: 10, want: false, reason: "excluded"},
+		{name: "first include rule", include: []string{"*.png", "shots/*"}, path: "shots/a.png", size: 10, want: true, reason: "'*.png'"},
+		// the size is checked after the patterns
+		{name: "too small", include: []string{"*.png"}, min: 100, path: "a.png", size: 10, want: false, reason: "smaller than min size (10 < 100 bytes)"},
+		{name: "too big", max: 100, path: "a.png", size: 101, want: false, reason: "bigger than max size (101 > 100 bytes)"},
+		{name: "size limits included", min: 10, max: 10, path: "a.png", size: 10, want: true},
+		{name: "excluded before size", exclude: []string{"*.tmp"}, min: 100, path: "a.tmp", size: 10, want: false, reason: "excluded"},
+		{name: "not included before size", include: []string{"*.png"}, min: 100, path: "a.txt", size: 10, want: false, reason: "not matched"},
+		{name: "unknown size", min: 100, path: "a.png", size: -1, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(tt.include, tt.exclude, tt.incRe, tt.excRe, tt.min, tt.max)
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			got := r.Match(tt.path, tt.size)
+			if got.Allowed != tt.want || !strings.Contains(got.Reason, tt.reason) {
+				t.Fatalf("Match(%q, %d) = %v %q, want %v %q", tt.path, tt.size, got.Allowed, got.Reason, tt.want, tt.reason)
+			}
+		})
+	}
+
+	var none *Rules
+	if got := none.Match("a.png", 10); !got.Allowed {
+		t.Fatalf("nil rules Match() = %+v, want allowed", got)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		incRe   []string
+		min     int64
+		max     int64
+	}{
+		{name: "negative min", min: -1},
+		{name: "negative max", max: -1},
+		{name: "min over max", min: 100, max: 10},
+		{name: "unclosed class", include: []string{"img[0-9.png"}},
+		{name: "trailing escape", exclude: []string{`a.png\`}},
+		{name: "invalid regex", incRe: []string{"a(.png"}},
+		{name: "empty class", include: []string{"img[].png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.include, tt.exclude, tt.incRe, nil, tt.min, tt.max); err == nil {
+				t.Fatal("New(): want error")
+			}
+		})
+	}
+
+	// no max is no limit, whatever the min
+	if _, err := New(nil, nil, nil, nil, 100, 0); err != nil {
+		t.Fatalf("New() min without max error: %v", err)
+	}
+}
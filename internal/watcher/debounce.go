@@ -0,0 +1,123 @@
+package watcher
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Clock is the time source of the debouncer and of the rename pairing, so their windows can be driven without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	// last time an event came or the size/mtime changed
+	changedAt time.Time
+	seen      bool
+}
+
+// Debouncer coalesce the Create/Write events per path and release a path only when its size and mtime
+// did not change for the whole window and the file can be opened exclusively (the writer is done)
+type Debouncer struct {
+	clock   Clock
+	window  time.Duration
+	pending map[string]*pendingFile
+
+	// replaceable for tests
+	stat     func(path string) (os.FileInfo, error)
+	lockable func(path string) bool
+}
+
+func NewDebouncer(clock Clock, window time.Duration) *Debouncer {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return &Debouncer{
+		clock:    clock,
+		window:   window,
+		pending:  make(map[string]*pendingFile),
+		stat:     os.Stat,
+		lockable: canLockExclusive,
+	}
+}
+
+// Touch register the path or restart its window, called for every Create/Write event
+func (d *Debouncer) Touch(path string) {
+	if p, ok := d.pending[path]; ok {
+		p.changedAt = d.clock.Now()
+		return
+	}
+
+	d.pending[path] = &pendingFile{changedAt: d.clock.Now()}
+}
+
+// Cancel drop the pending path, ex: the file is removed before the upload
+func (d *Debouncer) Cancel(path string) {
+	delete(d.pending, path)
+}
+
+// Len return the number of path still waiting
+func (d *Debouncer) Len() int {
+	return len(d.pending)
+}
+
+// Ready check all the pending paths and return (and forget) the stable ones, sorted so the order is predictable.
+// path that no longer exist or turn into a folder is dropped
+func (d *Debouncer) Ready() []string {
+	now := d.clock.Now()
+	ready := make([]string, 0)
+
+	for path, p := range d.pending {
+		info, err := d.stat(path)
+		if err != nil || info.IsDir() {
+			delete(d.pending, path)
+			continue
+		}
+
+		// size or mtime changed since the last check, the file is still written
+		if !p.seen || info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+			p.size = info.Size()
+			p.modTime = info.ModTime()
+			if p.seen {
+				p.changedAt = now
+			}
+			p.seen = true
+		}
+
+		if now.Sub(p.changedAt) < d.window {
+			continue
+		}
+
+		// still opened by the writer, try again on the next check
+		if !d.lockable(path) {
+			continue
+		}
+
+		ready = append(ready, path)
+		delete(d.pending, path)
+	}
+
+	sort.Strings(ready)
+	return ready
+}
+
+// Flush return (and forget) every pending path, stable or not, sorted. used on stop so the files still written
+// are queued and uploaded on the next start instead of lost
+func (d *Debouncer) Flush() []string {
+	paths := make([]string, 0, len(d.pending))
+	for path := range d.pending {
+		paths = append(paths, path)
+		delete(d.pending, path)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
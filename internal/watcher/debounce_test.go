@@ -0,0 +1,183 @@
+package watcher
+
+import (
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+type fakeInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i fakeInfo) Name() string       { return i.name }
+func (i fakeInfo) Size() int64        { return i.size }
+func (i fakeInfo) Mode() fs.FileMode  { return 0o644 }
+func (i fakeInfo) ModTime() time.Time { return i.modTime }
+func (i fakeInfo) IsDir() bool        { return i.dir }
+func (i fakeInfo) Sys() any           { return nil }
+
+// fakeFiles is the file system seen by the debouncer, the locked paths are still opened by the writer
+type fakeFiles struct {
+	files  map[string]*fakeInfo
+	locked map[string]bool
+}
+
+func newTestDebouncer(window time.Duration) (*Debouncer, *fakeClock, *fakeFiles) {
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}
+	files := &fakeFiles{files: make(map[string]*fakeInfo), locked: make(map[string]bool)}
+
+	d := NewDebouncer(clock, window)
+	d.stat = func(path string) (os.FileInfo, error) {
+		info, ok := files.files[path]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return *info, nil
+	}
+	d.lockable = func(path string) bool { return !files.locked[path] }
+
+	return d, clock, files
+}
+
+// write change the size and mtime of the file like the writer does
+func (f *fakeFiles) write(clock *fakeClock, path string, size int64) {
+	f.files[path] = &fakeInfo{name: path, size: size, modTime: clock.Now()}
+}
+
+func assertReady(t *testing.T, d *Debouncer, want ...string) {
+	t.Helper()
+	if want == nil {
+		want = []string{}
+	}
+	if got := d.Ready(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Ready() = %v, want %v", got, want)
+	}
+}
+
+func TestDebouncerQuietPeriod(t *testing.T) {
+	d, clock, files := newTestDebouncer(2 * time.Second)
+
+	files.write(clock, "a.png", 100)
+	d.Touch("a.png")
+	assertReady(t, d)
+
+	clock.Advance(time.Second)
+	assertReady(t, d)
+
+	clock.Advance(time.Second)
+	assertReady(t, d, "a.png")
+
+	if d.Len() != 0 {
+		t.Fatalf("Len() = %d after ready, want 0", d.Len())
+	}
+	clock.Advance(time.Minute)
+	assertReady(t, d)
+}
+
+func TestDebouncerResetWhileGrowing(t *testing.T) {
+	d, clock, files := newTestDebouncer(2 * time.Second)
+
+	files.write(clock, "a.png", 100)
+	d.Touch("a.png")
+	assertReady(t, d)
+
+	// grow without event, the change is seen on the check
+	clock.Advance(1500 * time.Millisecond)
+	files.write(clock, "a.png", 200)
+	assertReady(t, d)
+
+	clock.Advance(1500 * time.Millisecond)
+	assertReady(t, d)
+
+	// a new event restart the window too
+	d.Touch("a.png")
+	clock.Advance(1500 * time.Millisecond)
+	assertReady(t, d)
+
+	clock.Advance(500 * time.Millisecond)
+	assertReady(t, d, "a.png")
+}
+
+func TestDebouncerWaitLocked(t *testing.T) {
+	d, clock, files := newTestDebouncer(2 * time.Second)
+
+	files.write(clock, "a.png", 100)
+	files.locked["a.png"] = true
+	d.Touch("a.png")
+	assertReady(t, d)
+
+	clock.Advance(3 * time.Second)
+	assertReady(t, d)
+	clock.Advance(time.Minute)
+	assertReady(t, d)
+	if d.Len() != 1 {
+		t.Fatalf("Len() = %d while locked, want 1", d.Len())
+	}
+
+	files.locked["a.png"] = false
+	assertReady(t, d, "a.png")
+}
+
+func TestDebouncerRemovedBeforeFire(t *testing.T) {
+	d, clock, files := newTestDebouncer(2 * time.Second)
+
+	files.write(clock, "a.png", 100)
+	files.write(clock, "b.png", 100)
+	d.Touch("a.png")
+	d.Touch("b.png")
+	assertReady(t, d)
+
+	// removed without Cancel, the check drop it
+	delete(files.files, "a.png")
+	// removed with the Remove event
+	d.Cancel("b.png")
+
+	clock.Advance(3 * time.Second)
+	assertReady(t, d)
+	if d.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", d.Len())
+	}
+}
+
+func TestDebouncerFolderDropped(t *testing.T) {
+	d, clock, files := newTestDebouncer(2 * time.Second)
+
+	files.files["dir"] = &fakeInfo{name: "dir", dir: true, modTime: clock.Now()}
+	d.Touch("dir")
+
+	clock.Advance(3 * time.Second)
+	assertReady(t, d)
+	if d.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", d.Len())
+	}
+}
+
+func TestDebouncerFlush(t *testing.T) {
+	d, clock, files := newTestDebouncer(2 * time.Second)
+
+	files.write(clock, "b.png", 100)
+	files.write(clock, "a.png", 100)
+	d.Touch("b.png")
+	d.Touch("a.png")
+
+	if got, want := d.Flush(), []string{"a.png", "b.png"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Flush() = %v, want %v", got, want)
+	}
+	if d.Len() != 0 {
+		t.Fatalf("Len() = %d after flush, want 0", d.Len())
+	}
+}
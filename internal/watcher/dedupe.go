@@ -0,0 +1,238 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/checksum"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// policy for the file with the same content as a file already uploaded to the target, see Options.Dedupe
+const (
+	// not uploaded, only a duplicate record is stored
+	DedupeSkip = "skip"
+	// shortcut to the uploaded file (drive shortcut, hard link on local target), skip on storage without shortcut
+	DedupeLink = "link"
+	// upload every copy
+	DedupeUpload = "upload"
+)
+
+// sameContent report if the record already has the content of sum. the record uploaded before the hashes were
+// stored is compared with the md5 of the storage, and get the hashes when it match
+func (w *Watcher) sameContent(target *Target, record *models.Records, sum *checksum.Sum) (bool, error) {
+	if record.Status != models.RecordUploaded && record.Status != models.RecordDuplicate && record.Status != models.RecordSimilar {
+		return false, nil
+	}
+	if record.SHA256 != "" {
+		return record.SHA256 == sum.SHA256 && record.Size == sum.Size, nil
+	}
+	if record.Status != models.RecordUploaded {
+		return false, nil
+	}
+
+	obj, err := target.Storage.Stat(w.ctx, record.ItemID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// gone from the storage, the update upload it again
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("Error Get File: %w", err)
+	}
+
+	if obj.MD5 == "" || obj.MD5 != sum.MD5 || obj.Size != sum.Size {
+		return false, nil
+	}
+
+	record.Size = sum.Size
+	record.MD5 = sum.MD5
+	record.SHA256 = sum.SHA256
+
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := w.records.Update(tx, record); err != nil {
+		return false, fmt.Errorf("Error Store Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return true, nil
+}
+
+// findOriginal return the uploaded record of the target with the content of sum, nil when there is none
+func (w *Watcher) findOriginal(target *Target, sum *checksum.Sum) (*models.Records, error) {
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	record, err := w.records.FindByHash(tx, target.Name, sum.SHA256)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Find By Hash: %v", err)
+	}
+
+	return record, nil
+}
+
+// storeDuplicate record the file as a duplicate of the uploaded original, with a shortcut to it on the link policy.
+// the original gone from the storage cannot be linked, the file is uploaded instead
+func (w *Watcher) storeDuplicate(entry *Entry, target *Target, rel, filepath, mimeType string, sum *checksum.Sum, original *models.Records) error {
+	dataFile := models.Records{
+		Name:        path.Base(rel),
+		Date:        time.Now().String(),
+		Watch:       entry.Path,
+		Path:        rel,
+		MimeType:    mimeType,
+		Target:      target.Name,
+		Status:      models.RecordDuplicate,
+		Attempts:    1,
+		Size:        sum.Size,
+		MD5:         sum.MD5,
+		SHA256:      sum.SHA256,
+		DuplicateOf: original.ID,
+	}
+
+	action := "not uploaded"
+	if shortcutter, ok := target.Storage.(storage.Shortcutter); ok && w.dedupe == DedupeLink {
+		folderId, err := w.folderFor(entry, target, rel)
+		if err != nil {
+			return fmt.Errorf("Error Check Exist or Create Folder: %w", err)
+		}
+
+		obj, err := shortcutter.Shortcut(w.ctx, folderId, dataFile.Name, original.ItemID)
+		if errors.Is(err, storage.ErrNotFound) {
+			fmt.Printf("[%s] '%s' is gone from the storage, upload '%s'\n", target.Name, original.Path, rel)
+			return w.putFile(entry, target, rel, filepath, mimeType, sum, &similarity{})
+		} else if err != nil {
+			err = fmt.Errorf("Error Create Shortcut: %w", err)
+			if w.ctx.Err() == nil {
+				fmt.Printf("[%s] %v\n", target.Name, err)
+			}
+			return err
+		}
+
+		dataFile.ItemID = obj.ID
+		dataFile.FolderID = folderId
+		action = "linked"
+	}
+
+	if err := w.storeRecord(entry, target, &dataFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("[%s] '%s' has the same content as '%s', %s\n", target.Name, rel, original.Path, action)
+	return nil
+}
+
+// dropShortcut delete the shortcut of the duplicate record, already gone is fine
+func (w *Watcher) dropShortcut(target *Target, record *models.Records) error {
+	if record.ItemID == "" {
+		return nil
+	}
+
+	if err := target.Storage.Delete(w.ctx, record.ItemID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		fmt.Printf("[%s] Error Delete Shortcut: %v\n", target.Name, err)
+		return fmt.Errorf("Error Delete Shortcut: %w", err)
+	}
+
+	record.ItemID = ""
+	return nil
+}
+
+// handOver give the uploaded file of the record to its first duplicate, so removing or editing the file keep the
+// content for its copies. only the storage is changed here, the heir is stored with storeHandOver so no
+// transaction is open during the network calls. return nil when there is no duplicate
+func (w *Watcher) handOver(target *Target, record *models.Records) (*models.Records, error) {
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	duplicates, err := w.records.FindDuplicates(tx, record.ID)
+	tx.Rollback()
+	if err != nil {
+		return nil, fmt.Errorf("Error Find Duplicates: %v", err)
+	}
+	if len(*duplicates) == 0 {
+		return nil, nil
+	}
+
+	heir := (*duplicates)[0]
+	if err := w.dropShortcut(target, &heir); err != nil {
+		return nil, err
+	}
+
+	heir.ItemID = record.ItemID
+	heir.FolderID = record.FolderID
+	heir.Name = record.Name
+	heir.Status = models.RecordUploaded
+	heir.DuplicateOf = 0
+
+	// the uploaded file get the name of the heir when the storage can rename it
+	if mover, ok := target.Storage.(storage.Mover); ok && path.Base(heir.Path) != record.Name {
+		obj, err := mover.Move(w.ctx, record.ItemID, record.FolderID, path.Base(heir.Path))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("Error Move File: %w", err)
+		} else if err == nil {
+			heir.ItemID = obj.ID
+			heir.Name = path.Base(heir.Path)
+		}
+	}
+
+	return &heir, nil
+}
+
+// storeHandOver store the heir of handOver on tx, the other duplicates of the record now point to it
+func (w *Watcher) storeHandOver(tx *sql.Tx, target *Target, record, heir *models.Records) error {
+	if err := w.records.Update(tx, heir); err != nil {
+		return fmt.Errorf("Error Store Record: %v", err)
+	}
+	if err := w.records.Relink(tx, record.ID, heir.ID); err != nil {
+		return fmt.Errorf("Error Relink Duplicates: %v", err)
+	}
+
+	fmt.Printf("[%s] Keep '%s' on the storage for its duplicate '%s'\n", target.Name, record.Path, heir.Path)
+	return nil
+}
+
+// handOverRecord hand the uploaded file of the edited record over to its duplicates and forget the record, so the
+// edited file is uploaded as a new file. return false when there is no duplicate
+func (w *Watcher) handOverRecord(target *Target, record *models.Records) (bool, error) {
+	heir, err := w.handOver(target, record)
+	if err != nil || heir == nil {
+		return false, err
+	}
+
+	// the storage is already changed, the result is stored even when stopping meanwhile
+	tx, err := w.db.BeginTx(context.WithoutCancel(w.ctx), nil)
+	if err != nil {
+		return false, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := w.storeHandOver(tx, target, record, heir); err != nil {
+		return false, err
+	}
+
+	if err := w.records.Delete(tx, record.ID); err != nil {
+		return false, fmt.Errorf("Error Delete Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return true, nil
+}
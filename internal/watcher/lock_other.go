@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package watcher
+
+import "os"
+
+// canLockExclusive only check the file can be opened, there is no locking to rely on
+func canLockExclusive(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	file.Close()
+
+	return true
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// canLockExclusive try to take non blocking exclusive flock, it only detect writer that also use flock
+// but it is the best that can be done without mandatory locking
+func canLockExclusive(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return false
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	return true
+}
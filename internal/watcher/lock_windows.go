@@ -0,0 +1,26 @@
+//go:build windows
+
+package watcher
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// canLockExclusive open the file for writing, windows refuse it with sharing violation while the writer still has it open
+func canLockExclusive(path string) bool {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		// read only file cannot be opened for writing, but it is not held by a writer either
+		return errors.Is(err, fs.ErrPermission) && isReadOnly(path)
+	}
+	file.Close()
+
+	return true
+}
+
+func isReadOnly(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().Perm()&0200 == 0
+}
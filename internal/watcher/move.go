@@ -0,0 +1,298 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/checksum"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// the Create event of the new name come right after the Rename event, a later one is another file
+const renamePairWindow = 500 * time.Millisecond
+
+// renamedFile is the old name of a Rename event waiting for the Create event of its new name
+type renamedFile struct {
+	entry *Entry
+	rel   string
+	at    time.Time
+}
+
+// pairRename return the pending rename when the event is the Create of its new name on the same entry. any other
+// event mean the file left the watched folders, it is removed from the storage like a Remove event
+func (w *Watcher) pairRename(entry *Entry, event fsnotify.Event) *renamedFile {
+	renamed := w.renamed
+	if renamed == nil {
+		return nil
+	}
+	w.renamed = nil
+
+	if entry == renamed.entry && event.Op&fsnotify.Create == fsnotify.Create && w.clock.Now().Sub(renamed.at) <= renamePairWindow {
+		return renamed
+	}
+
+	w.queueRemove(renamed.entry, renamed.rel)
+	return nil
+}
+
+// expireRename queue the remove of the pending rename without Create event after the pair window, or right away with force
+func (w *Watcher) expireRename(force bool) {
+	if w.renamed == nil || (!force && w.clock.Now().Sub(w.renamed.at) <= renamePairWindow) {
+		return
+	}
+
+	fmt.Println("Moved out of the watch: ", w.renamed.rel)
+	w.queueRemove(w.renamed.entry, w.renamed.rel)
+	w.renamed = nil
+}
+
+// queueMove queue the move of the uploaded file from the old to the new path on every target. when the rules skip
+// the new name the file is removed, and when they skipped the old name it is uploaded like a new file
+func (w *Watcher) queueMove(entry *Entry, from, rel, filepath string) {
+	info, err := os.Stat(filepath)
+	if err != nil || info.IsDir() {
+		return
+	}
+
+	fromAllowed := entry.Rules.Match(from, info.Size()).Allowed
+	result := entry.Rules.Match(rel, info.Size())
+
+	switch {
+	case !result.Allowed:
+		fmt.Printf("Skip '%s': %s\n", rel, result.Reason)
+		if fromAllowed {
+			w.queueRemove(entry, from)
+		}
+	case !fromAllowed:
+		w.debouncer.Touch(filepath)
+	default:
+		fmt.Printf("Queue move: %s -> %s\n", from, rel)
+		w.enqueue(entry, entry.Targets, models.Job{Kind: models.JobMove, Path: rel, FromPath: from})
+	}
+}
+
+// moveFile run the move job, the uploaded file of the old path is renamed on the storage and its record follow it.
+// without uploaded file (failed upload, gone from the storage) the new path is uploaded, and on storage that
+// cannot rename the old file is deleted and the new path uploaded
+func (w *Watcher) moveFile(entry *Entry, target *Target, first bool, job *models.Job) error {
+	// the new name replaced an uploaded file, it is removed like on a remove event
+	if replaced, err := w.findRecord(entry, target, job.Path); err != nil {
+		return err
+	} else if replaced != nil {
+		if err := w.removeFrom(entry, target, first, job.Path); err != nil {
+			return err
+		}
+	}
+
+	mover, ok := target.Storage.(storage.Mover)
+	if !ok {
+		if err := w.removeFrom(entry, target, first, job.FromPath); err != nil {
+			return err
+		}
+		return w.uploadFile(entry, target, job)
+	}
+
+	record, err := w.findRecord(entry, target, job.FromPath)
+	if err != nil {
+		return err
+	} else if record != nil && record.Target == "" && !first {
+		record = nil
+	}
+
+	if record != nil && (record.Status == models.RecordDuplicate && record.ItemID == "" || record.Status == models.RecordSimilar) {
+		// duplicate without shortcut and skipped near duplicate have nothing on the storage, only the record follow the file
+		record.Name = path.Base(job.Path)
+		record.Watch = entry.Path
+		record.Path = job.Path
+		return w.updateRecord(record)
+	}
+
+	if record != nil && (record.Status == models.RecordUploaded || record.Status == models.RecordDuplicate) {
+		err := w.moveRecord(mover, entry, target, record, job.Path)
+		if err == nil {
+			return w.updateRecord(record)
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		fmt.Printf("[%s] '%s' is gone from the storage, upload '%s' again\n", target.Name, job.FromPath, job.Path)
+	}
+
+	// failed upload or file gone from the storage, the upload of the new path replace it
+	if record != nil {
+		if err := w.forgetRecord(record.ID); err != nil {
+			return err
+		}
+	}
+
+	return w.uploadFile(entry, target, job)
+}
+
+// moveRecord rename the uploaded file of the record to rel on the storage and set the record to its new place, the
+// caller store it. the file stay on its folder when only the name changed, a new local subfolder is mirrored inside
+// the daily folder of today
+func (w *Watcher) moveRecord(mover storage.Mover, entry *Entry, target *Target, record *models.Records, rel string) error {
+	folderId := record.FolderID
+	if path.Dir(record.Path) != path.Dir(rel) {
+		var err error
+		if folderId, err = w.folderFor(entry, target, rel); err != nil {
+			return fmt.Errorf("Error Check Exist or Create Folder: %w", err)
+		}
+	}
+
+	obj, err := mover.Move(w.ctx, record.ItemID, folderId, path.Base(rel))
+	if err != nil {
+		return fmt.Errorf("Error Move File: %w", err)
+	}
+
+	from := record.Path
+	record.ItemID = obj.ID
+	record.FolderID = folderId
+	record.Name = path.Base(rel)
+	record.Watch = entry.Path
+	record.Path = rel
+
+	fmt.Printf("Move File on %s Success: %s -> %s\n", target.Name, from, rel)
+	return nil
+}
+
+// moveMatching look for the removed file with the same content on the queued deletes of the target, so a move that
+// was not seen as a rename (other folder, rename without Create event) is renamed on the storage instead of uploaded
+// again. the content is compared with the md5 of the storage, backend without md5 never match. return true when moved
+func (w *Watcher) moveMatching(entry *Entry, target *Target, first bool, rel, localPath string, size int64) (bool, error) {
+	mover, ok := target.Storage.(storage.Mover)
+	if !ok {
+		return false, nil
+	}
+
+	type candidate struct {
+		jobID  int
+		record *models.Records
+	}
+
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+
+	deletes, err := w.jobs.FindByKind(tx, models.JobDelete, entry.Path, target.Name)
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("Error Find Delete Jobs: %v", err)
+	}
+
+	var candidates []candidate
+	for _, job := range *deletes {
+		if job.Path == rel {
+			continue
+		}
+
+		record, err := w.records.FindByPath(tx, entry.Path, job.Path, target.Name)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("Error Find By Path: %v", err)
+		}
+
+		if record.Status == models.RecordUploaded && (record.Target != "" || first) {
+			candidates = append(candidates, candidate{jobID: job.ID, record: record})
+		}
+	}
+	tx.Rollback()
+
+	if len(candidates) == 0 {
+		return false, nil
+	}
+
+	sum, err := checksum.File(localPath)
+	if err != nil {
+		return false, fmt.Errorf("Error Hash File: %w", err)
+	}
+
+	for _, c := range candidates {
+		obj, err := target.Storage.Stat(w.ctx, c.record.ItemID)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return false, fmt.Errorf("Error Get File: %w", err)
+		}
+
+		if obj.MD5 == "" || obj.MD5 != sum.MD5 || obj.Size != size {
+			continue
+		}
+
+		moved, err := w.claimMove(mover, entry, target, c.jobID, c.record, rel)
+		if moved || err != nil {
+			return moved, err
+		}
+	}
+
+	return false, nil
+}
+
+// claimMove move the record of the queued delete job to rel and drop the job. the job is marked as started first so
+// it does not run during the move, false when it already started or its record is gone
+func (w *Watcher) claimMove(mover storage.Mover, entry *Entry, target *Target, jobID int, found *models.Records, rel string) (bool, error) {
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	record, err := w.records.FindByPath(tx, entry.Path, found.Path, target.Name)
+	if err == sql.ErrNoRows || (err == nil && record.ID != found.ID) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("Error Find By Path: %v", err)
+	}
+
+	if claimed, err := w.jobs.Start(tx, jobID, time.Now().Unix()); err != nil {
+		return false, fmt.Errorf("Error Claim Job: %v", err)
+	} else if !claimed {
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("Error Commit: %v", err)
+	}
+
+	moveErr := w.moveRecord(mover, entry, target, record, rel)
+
+	// the storage is already changed, the result is stored even when stopping meanwhile
+	tx, err = w.db.BeginTx(context.WithoutCancel(w.ctx), nil)
+	if err != nil {
+		return false, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if moveErr != nil {
+		// the delete job run as usual
+		if err := w.jobs.Release(tx, jobID); err != nil {
+			return false, fmt.Errorf("Error Release Job: %v", err)
+		}
+	} else {
+		if err := w.records.Update(tx, record); err != nil {
+			return false, fmt.Errorf("Error Store Record: %v", err)
+		}
+		if err := w.jobs.Delete(tx, jobID); err != nil {
+			return false, fmt.Errorf("Error Delete Job: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("Error Commit: %v", err)
+	}
+
+	if errors.Is(moveErr, storage.ErrNotFound) {
+		return false, nil
+	}
+	return moveErr == nil, moveErr
+}
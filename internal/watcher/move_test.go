@@ -0,0 +1,262 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/momokii/ss-watcher/internal/models"
+)
+
+// newMoveWatcher return the watcher with a local target, a storage that can rename, and the clock of the pairing
+func newMoveWatcher(t *testing.T) (*Watcher, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}
+	w, _ := newLocalWatcher(t, Options{Clock: clock, Workers: 2})
+
+	return w, clock
+}
+
+// emit process the event of the file like the loop of Run
+func emit(w *Watcher, op fsnotify.Op, rel string) {
+	event := fsnotify.Event{Name: filepath.Join(w.entries[0].Path, filepath.FromSlash(rel)), Op: op}
+
+	entry, rel := w.entryFor(event.Name)
+	renamed := w.pairRename(entry, event)
+	w.handleEvent(entry, rel, event, renamed)
+}
+
+func writeLocal(t *testing.T, w *Watcher, rel, content string) string {
+	t.Helper()
+
+	p := filepath.Join(w.entries[0].Path, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// upload write the file and run its upload like after the debounce window, return its record
+func upload(t *testing.T, w *Watcher, rel, content string) *models.Records {
+	t.Helper()
+
+	p := writeLocal(t, w, rel, content)
+	w.queueUpload(&w.entries[0], rel, p)
+	runQueue(t, w)
+
+	record := localRecord(t, w, rel)
+	if record == nil || record.Status != models.RecordUploaded {
+		t.Fatalf("record of '%s' = %+v, want uploaded", rel, record)
+	}
+	return record
+}
+
+func localRecord(t *testing.T, w *Watcher, rel string) *models.Records {
+	t.Helper()
+
+	record, err := w.findRecord(&w.entries[0], &w.entries[0].Targets[0], rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func queuedJobs(t *testing.T, w *Watcher) []models.Job {
+	t.Helper()
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	jobs, err := w.jobs.FindAll(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *jobs
+}
+
+func renameLocal(t *testing.T, w *Watcher, from, to string) {
+	t.Helper()
+
+	dir := w.entries[0].Path
+	if err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenamePaired(t *testing.T) {
+	w, clock := newMoveWatcher(t)
+	original := upload(t, w, "a.png", "screenshot")
+
+	renameLocal(t, w, "a.png", "b.png")
+	emit(w, fsnotify.Rename, "a.png")
+	clock.Advance(renamePairWindow - 100*time.Millisecond)
+	emit(w, fsnotify.Create, "b.png")
+
+	jobs := queuedJobs(t, w)
+	if len(jobs) != 1 || jobs[0].Kind != models.JobMove || jobs[0].FromPath != "a.png" || jobs[0].Path != "b.png" {
+		t.Fatalf("queued %+v, want only the move of a.png to b.png", jobs)
+	}
+
+	runQueue(t, w)
+
+	// the same record follow the file, the file is renamed on the storage and not uploaded again
+	moved := localRecord(t, w, "b.png")
+	if moved == nil || moved.ID != original.ID || moved.ItemID != filepath.Join(original.FolderID, "b.png") {
+		t.Fatalf("record of b.png = %+v, want record #%d moved", moved, original.ID)
+	}
+	if localRecord(t, w, "a.png") != nil {
+		t.Fatal("record of a.png still there")
+	}
+	if _, err := os.Stat(original.ItemID); !os.IsNotExist(err) {
+		t.Fatalf("old file still on the storage: %v", err)
+	}
+	if data, err := os.ReadFile(moved.ItemID); err != nil || string(data) != "screenshot" {
+		t.Fatalf("moved file = %q, %v", data, err)
+	}
+}
+
+func TestRenameExpired(t *testing.T) {
+	w, clock := newMoveWatcher(t)
+	upload(t, w, "a.png", "screenshot")
+
+	renameLocal(t, w, "a.png", "b.png")
+	emit(w, fsnotify.Rename, "a.png")
+
+	// still waiting for the Create event of the new name
+	clock.Advance(renamePairWindow)
+	w.expireRename(false)
+	if jobs := queuedJobs(t, w); len(jobs) != 0 {
+		t.Fatalf("queued %+v inside the pair window", jobs)
+	}
+
+	// moved out of the watch, the file is removed from the storage
+	clock.Advance(time.Millisecond)
+	w.expireRename(false)
+	jobs := queuedJobs(t, w)
+	if len(jobs) != 1 || jobs[0].Kind != models.JobDelete || jobs[0].Path != "a.png" {
+		t.Fatalf("queued %+v, want the delete of a.png", jobs)
+	}
+	if w.renamed != nil {
+		t.Fatal("expired rename still pending")
+	}
+}
+
+func TestRenameCreateAfterWindow(t *testing.T) {
+	w, clock := newMoveWatcher(t)
+	upload(t, w, "a.png", "screenshot")
+
+	renameLocal(t, w, "a.png", "b.png")
+	emit(w, fsnotify.Rename, "a.png")
+	clock.Advance(renamePairWindow + time.Millisecond)
+	emit(w, fsnotify.Create, "b.png")
+
+	// a later Create is another file: the old name is deleted and the new one wait for its debounce
+	jobs := queuedJobs(t, w)
+	if len(jobs) != 1 || jobs[0].Kind != models.JobDelete || jobs[0].Path != "a.png" {
+		t.Fatalf("queued %+v, want only the delete of a.png", jobs)
+	}
+	if w.debouncer.Len() != 1 {
+		t.Fatalf("%d file(s) waiting for the debounce, want b.png", w.debouncer.Len())
+	}
+}
+
+func TestMoveClaimedByContent(t *testing.T) {
+	w, _ := newMoveWatcher(t)
+	original := upload(t, w, "a.png", "screenshot")
+
+	// moved to a subfolder, seen as a remove and a create
+	renameLocal(t, w, "a.png", "b.png")
+	emit(w, fsnotify.Remove, "a.png")
+	if err := os.MkdirAll(filepath.Join(w.entries[0].Path, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	renameLocal(t, w, "b.png", "sub/b.png")
+	w.queueUpload(&w.entries[0], "sub/b.png", filepath.Join(w.entries[0].Path, "sub", "b.png"))
+
+	runQueue(t, w)
+
+	moved := localRecord(t, w, "sub/b.png")
+	if moved == nil || moved.ID != original.ID {
+		t.Fatalf("record of sub/b.png = %+v, want record #%d moved", moved, original.ID)
+	}
+	if localRecord(t, w, "a.png") != nil {
+		t.Fatal("record of a.png still there")
+	}
+	// the delete job was claimed by the move
+	if jobs := queuedJobs(t, w); len(jobs) != 0 {
+		t.Fatalf("queued %+v, want none", jobs)
+	}
+	if _, err := os.Stat(original.ItemID); !os.IsNotExist(err) {
+		t.Fatalf("old file still on the storage: %v", err)
+	}
+}
+
+func TestMoveOtherContentNotClaimed(t *testing.T) {
+	w, _ := newMoveWatcher(t)
+	original := upload(t, w, "a.png", "screenshot")
+
+	writeLocal(t, w, "b.png", "another screenshot")
+	if err := os.Remove(filepath.Join(w.entries[0].Path, "a.png")); err != nil {
+		t.Fatal(err)
+	}
+	emit(w, fsnotify.Remove, "a.png")
+	w.queueUpload(&w.entries[0], "b.png", filepath.Join(w.entries[0].Path, "b.png"))
+
+	runQueue(t, w)
+
+	uploaded := localRecord(t, w, "b.png")
+	if uploaded == nil || uploaded.ID == original.ID {
+		t.Fatalf("record of b.png = %+v, want a new upload", uploaded)
+	}
+	// the delete wait for the move hold
+	if jobs := queuedJobs(t, w); len(jobs) != 1 || jobs[0].Kind != models.JobDelete {
+		t.Fatalf("queued %+v, want the held delete of a.png", jobs)
+	}
+}
+
+func TestMoveClaimedOnce(t *testing.T) {
+	w, _ := newMoveWatcher(t)
+	original := upload(t, w, "a.png", "screenshot")
+
+	// two copies of the removed file, both uploads look for the same delete job on two workers
+	writeLocal(t, w, "b.png", "screenshot")
+	writeLocal(t, w, "c.png", "screenshot")
+	if err := os.Remove(filepath.Join(w.entries[0].Path, "a.png")); err != nil {
+		t.Fatal(err)
+	}
+	emit(w, fsnotify.Remove, "a.png")
+	for _, rel := range []string{"b.png", "c.png"} {
+		w.queueUpload(&w.entries[0], rel, filepath.Join(w.entries[0].Path, rel))
+	}
+
+	runQueue(t, w)
+
+	claimed := 0
+	for _, rel := range []string{"b.png", "c.png"} {
+		record := localRecord(t, w, rel)
+		if record == nil {
+			t.Fatalf("no record of %s", rel)
+		}
+		if record.ID == original.ID {
+			claimed++
+		}
+	}
+	if claimed != 1 {
+		t.Fatalf("record #%d claimed by %d files, want 1", original.ID, claimed)
+	}
+	if localRecord(t, w, "a.png") != nil {
+		t.Fatal("record of a.png still there")
+	}
+	if jobs := queuedJobs(t, w); len(jobs) != 0 {
+		t.Fatalf("queued %+v, want none", jobs)
+	}
+}
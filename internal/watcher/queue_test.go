@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// fakeStorage keep the uploads in memory, Put wait delay and fail with the error of the file name
+type fakeStorage struct {
+	mu        sync.Mutex
+	delay     time.Duration
+	fail      map[string]error
+	puts      []string
+	active    int
+	maxActive int
+}
+
+func (s *fakeStorage) Kind() string { return "fake" }
+
+func (s *fakeStorage) EnsureFolder(ctx context.Context, parentID, name string) (string, error) {
+	return parentID + "/" + name, nil
+}
+
+func (s *fakeStorage) Put(ctx context.Context, folderID, name, localPath, mimeType string) (*storage.Object, error) {
+	s.mu.Lock()
+	s.puts = append(s.puts, name)
+	s.active++
+	s.maxActive = max(s.maxActive, s.active)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if err := s.fail[name]; err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.Object{ID: folderID + "/" + name, Name: name, ParentID: folderID, Size: info.Size()}, nil
+}
+
+func (s *fakeStorage) Delete(ctx context.Context, id string) error {
+	return storage.ErrNotFound
+}
+
+func (s *fakeStorage) Stat(ctx context.Context, id string) (*storage.Object, error) {
+	return nil, storage.ErrNotFound
+}
+
+func (s *fakeStorage) Share(ctx context.Context, folderID, email string) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+func (s *fakeStorage) uploaded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := append([]string(nil), s.puts...)
+	sort.Strings(names)
+	return names
+}
+
+// newTestWatcher return the watcher of a temp folder with the files queued for upload to the fake storage
+func newTestWatcher(t *testing.T, store storage.Storage, workers int, files ...string) *Watcher {
+	t.Helper()
+
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dir := t.TempDir()
+	entry := Entry{
+		Path:        dir,
+		Targets:     []Target{{Name: "fake", Storage: store, BaseFolderID: "base"}},
+		DailyPrefix: "SS_",
+	}
+
+	w := New(db, []Entry{entry}, Options{Workers: workers})
+	for _, name := range files {
+		// different content, so none is a duplicate of another
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.enqueue(&w.entries[0], w.entries[0].Targets, models.Job{Kind: models.JobUpload, Path: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w
+}
+
+func runQueue(t *testing.T, w *Watcher) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	w.RunQueue(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("RunQueue() did not finish")
+	}
+
+	// RunQueue cancel the context of the watcher on return, the next events of the test need a live one
+	w.bind(context.Background())
+}
+
+func TestRunPoolConcurrent(t *testing.T) {
+	store := &fakeStorage{delay: 200 * time.Millisecond}
+	w := newTestWatcher(t, store, 2, "a.png", "b.png")
+
+	runQueue(t, w)
+
+	if got := store.uploaded(); len(got) != 2 {
+		t.Fatalf("uploaded %v, want both files", got)
+	}
+	// the database is not held during the upload, so the second worker upload at the same time
+	if store.maxActive != 2 {
+		t.Fatalf("%d upload(s) at the same time, want 2", store.maxActive)
+	}
+}
+
+func TestRunPoolFileError(t *testing.T) {
+	store := &fakeStorage{fail: map[string]error{"a.png": errors.New("invalid name")}}
+	w := newTestWatcher(t, store, 1, "a.png", "b.png", "c.png")
+
+	runQueue(t, w)
+
+	// the error of one file does not hold the other jobs of the target
+	if got := store.uploaded(); len(got) != 3 {
+		t.Fatalf("uploaded %v, want every file tried", got)
+	}
+}
+
+func TestRunPoolUnavailable(t *testing.T) {
+	store := &fakeStorage{fail: map[string]error{"a.png": storage.ErrUnavailable}}
+	w := newTestWatcher(t, store, 1, "a.png", "b.png", "c.png")
+
+	runQueue(t, w)
+
+	// every call would fail the same way, the target wait before its next job
+	if got := store.uploaded(); len(got) != 1 || got[0] != "a.png" {
+		t.Fatalf("uploaded %v, want only a.png tried", got)
+	}
+}
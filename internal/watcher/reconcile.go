@@ -0,0 +1,401 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// policy for the remote file without local file, see ReconcileOptions.Orphans
+const (
+	// only report it
+	OrphanReport = "report"
+	// forget the record, the remote copy stay as archive
+	OrphanKeep = "keep"
+	// delete the remote copy, like a live remove event
+	OrphanDelete = "delete"
+)
+
+// kind of the drift found by Reconcile
+const (
+	// local file without record and without queued upload
+	DriftNotUploaded = "not uploaded"
+	// uploaded file removed on the local folder while the watcher was stopped
+	DriftLocalRemoved = "removed locally"
+	// uploaded file gone from the storage, the local file is still there
+	DriftRemoteMissing = "missing on remote"
+	// local file size is not the uploaded one
+	DriftModified = "modified"
+	// file on the daily folders that no record know
+	DriftUntracked = "untracked on remote"
+	// record of a file gone on both side
+	DriftStale = "stale record"
+)
+
+// ReconcileOptions of one Reconcile run
+type ReconcileOptions struct {
+	// also list the daily folders on the storage, without it only the local folders and the records are compared
+	Full bool
+	// OrphanReport, OrphanKeep or OrphanDelete, empty is OrphanReport
+	Orphans string
+	// only report, nothing is queued, deleted or forgotten
+	DryRun bool
+}
+
+// Drift is one difference between the local folder, the records table and the storage
+type Drift struct {
+	Kind   string
+	Watch  string
+	Path   string
+	Target string
+	// id of the file on the storage, empty when it is only local
+	RemoteID string
+	// what was done, or what would be done on dry run
+	Action string
+}
+
+// ReconcileReport is the result of Reconcile
+type ReconcileReport struct {
+	Drifts []Drift
+	// target that could not be compared with the storage, with the reason
+	Skipped map[string]string
+}
+
+// Reconcile compare every watch entry with the records table (and the storage on full run) and queue the uploads
+// and deletes that the live events missed, ex: files created or removed while the watcher was stopped.
+// files that already have a queued job are left to the queue
+func (w *Watcher) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	if opts.Orphans == "" {
+		opts.Orphans = OrphanReport
+	}
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	records, err := w.records.FindAll(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Error Find All Records: %v", err)
+	}
+	jobs, err := w.jobs.FindAll(tx)
+	tx.Rollback()
+	if err != nil {
+		return nil, fmt.Errorf("Error Find All Jobs: %v", err)
+	}
+
+	// file and target with a job on the queue, key is <watch>|<path>|<target>
+	queued := make(map[string]bool, len(*jobs))
+	for _, job := range *jobs {
+		queued[job.Watch+"|"+job.Path+"|"+job.Target] = true
+	}
+
+	report := &ReconcileReport{Skipped: make(map[string]string)}
+
+	for i := range w.entries {
+		entry := &w.entries[i]
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		local, err := w.localFiles(entry)
+		if err != nil {
+			return report, fmt.Errorf("Error Walk '%s': %v", entry.Path, err)
+		}
+
+		for j := range entry.Targets {
+			target := &entry.Targets[j]
+
+			// nil when the storage is not compared, only the local folder and the records
+			var remote map[string]*storage.Object
+			var listed map[string]bool
+			if opts.Full {
+				folders := recordFolders(entry, target, j == 0, *records)
+				if remote, listed, err = w.remoteFiles(ctx, entry, target, folders); err != nil {
+					fmt.Printf("[%s] Skip storage check of '%s': %v\n", target.Name, entry.Path, err)
+					report.Skipped[target.Name] = err.Error()
+				}
+			}
+
+			w.reconcileTarget(ctx, report, opts, entry, target, j == 0, local, *records, queued, remote, listed)
+		}
+	}
+
+	return report, nil
+}
+
+// reconcileTarget compare one target of the entry, local is the size by relative path, remote the files by id and
+// listed the folders they were read from. a file is only missing on the storage when its folder was listed
+func (w *Watcher) reconcileTarget(ctx context.Context, report *ReconcileReport, opts ReconcileOptions, entry *Entry, target *Target, first bool, local map[string]int64, records []models.Records, queued map[string]bool, remote map[string]*storage.Object, listed map[string]bool) {
+	// last record of every path, the exact watch and target win over the records of the older version
+	byPath := make(map[string]*models.Records)
+	referenced := make(map[string]bool)
+	for i := range records {
+		r := &records[i]
+
+		ownTarget := r.Target == target.Name || (r.Target == "" && first)
+		if ownTarget && r.ItemID != "" {
+			// the storage can be shared by several watches, any record of the target keep the file
+			referenced[r.ItemID] = true
+		}
+		if !ownTarget || (r.Watch != entry.Path && r.Watch != "") {
+			continue
+		}
+
+		if prev, ok := byPath[r.Path]; ok && (prev.Watch != "" && r.Watch == "" || prev.Target != "" && r.Target == "") {
+			continue
+		}
+		byPath[r.Path] = r
+	}
+
+	// nothing is done for the report only drift, so there is no "would" on dry run
+	const reportOnly = "report only"
+
+	drift := func(kind, rel, remoteID, action string) *Drift {
+		d := Drift{Kind: kind, Watch: entry.Path, Path: rel, Target: target.Name, RemoteID: remoteID, Action: action}
+		if opts.DryRun && action != reportOnly {
+			d.Action = "would " + action
+		}
+		report.Drifts = append(report.Drifts, d)
+		fmt.Printf("[%s] Sync '%s' %s: %s\n", target.Name, rel, kind, d.Action)
+		return &report.Drifts[len(report.Drifts)-1]
+	}
+
+	// run the action unless dry run, the error is kept on the drift
+	apply := func(d *Drift, fn func() error) {
+		if opts.DryRun {
+			return
+		}
+		if err := fn(); err != nil {
+			d.Action += " (failed: " + err.Error() + ")"
+			fmt.Printf("[%s] Error Sync '%s': %v\n", target.Name, d.Path, err)
+		}
+	}
+
+	queue := func(rel, kind string) func() error {
+		return func() error { return w.enqueue(entry, []Target{*target}, models.Job{Kind: kind, Path: rel}) }
+	}
+
+	// the file of a folder that was not listed (other daily template or prefix, record without folder) is unknown
+	missing := func(record *models.Records) bool {
+		return remote != nil && remote[record.ItemID] == nil && listed[record.FolderID]
+	}
+
+	for _, rel := range sortedKeys(local) {
+		size := local[rel]
+		if queued[entry.Path+"|"+rel+"|"+target.Name] {
+			continue
+		}
+
+		record, ok := byPath[rel]
+		switch {
+		case !ok || record.Status == models.RecordFailed:
+			apply(drift(DriftNotUploaded, rel, "", "queue upload"), queue(rel, models.JobUpload))
+
+		case record.Status != models.RecordUploaded || remote == nil:
+			// rejected upload is shown on the records, without the storage listing there is nothing to compare
+
+		case missing(record):
+			d := drift(DriftRemoteMissing, rel, record.ItemID, "upload again")
+			apply(d, func() error {
+				if err := w.forgetRecord(record.ID); err != nil {
+					return err
+				}
+				return queue(rel, models.JobUpload)()
+			})
+
+		case remote[record.ItemID] != nil && !remote[record.ItemID].IsFolder && remote[record.ItemID].Size != size:
+			apply(drift(DriftModified, rel, record.ItemID, "queue upload"), queue(rel, models.JobUpload))
+		}
+	}
+
+	for _, rel := range sortedKeys(byPath) {
+		record := byPath[rel]
+		if _, ok := local[rel]; ok || queued[entry.Path+"|"+rel+"|"+target.Name] {
+			continue
+		}
+
+		// duplicate without shortcut and skipped near duplicate have nothing on the storage
+		noRemote := record.Status == models.RecordDuplicate && record.ItemID == "" || record.Status == models.RecordSimilar
+		if noRemote || (record.Status != models.RecordUploaded && record.Status != models.RecordDuplicate) || missing(record) {
+			d := drift(DriftStale, rel, record.ItemID, "forget record")
+			apply(d, func() error { return w.forgetRecord(record.ID) })
+			continue
+		}
+
+		switch opts.Orphans {
+		case OrphanDelete:
+			apply(drift(DriftLocalRemoved, rel, record.ItemID, "queue delete"), queue(rel, models.JobDelete))
+		case OrphanKeep:
+			d := drift(DriftLocalRemoved, rel, record.ItemID, "keep remote, forget record")
+			apply(d, func() error { return w.forgetRecord(record.ID) })
+		default:
+			drift(DriftLocalRemoved, rel, record.ItemID, reportOnly)
+		}
+	}
+
+	for _, id := range sortedKeys(remote) {
+		obj := remote[id]
+		if referenced[id] || obj.IsFolder {
+			continue
+		}
+
+		switch opts.Orphans {
+		case OrphanDelete:
+			d := drift(DriftUntracked, obj.Name, id, "delete remote")
+			apply(d, func() error { return target.Storage.Delete(ctx, id) })
+		case OrphanKeep:
+			drift(DriftUntracked, obj.Name, id, reportOnly)
+		default:
+			drift(DriftUntracked, obj.Name, id, reportOnly)
+		}
+	}
+}
+
+// localFiles return the size of every file of the entry allowed by the rules, by slash separated relative path
+func (w *Watcher) localFiles(entry *Entry) (map[string]int64, error) {
+	files := make(map[string]int64)
+
+	err := filepath.WalkDir(entry.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p != entry.Path {
+				fmt.Println("Error Walk: ", err)
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(entry.Path, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if entry.Rules.Match(rel, info.Size()).Allowed {
+			files[rel] = info.Size()
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// remoteFiles list every file inside the daily folders of the target base folder and inside the folders of the
+// records, by remote id, with the id of every folder read. other folders on the base folder are not touched, they
+// are not created by the watcher. the folder of the records is read too because the daily folders are only found
+// by the prefix, ex: a daily template without it or the files of an older prefix
+func (w *Watcher) remoteFiles(ctx context.Context, entry *Entry, target *Target, folders []string) (map[string]*storage.Object, map[string]bool, error) {
+	lister, ok := target.Storage.(storage.Lister)
+	if !ok {
+		return nil, nil, storage.ErrNotSupported
+	}
+
+	top, err := lister.List(ctx, target.BaseFolderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := make(map[string]*storage.Object)
+	listed := make(map[string]bool)
+
+	// list add the files of the folder, and the files of its subfolders with recursive
+	var list func(folderID string, recursive bool) error
+	list = func(folderID string, recursive bool) error {
+		objects, err := lister.List(ctx, folderID)
+		if errors.Is(err, storage.ErrNotFound) {
+			// folder removed with its files
+			listed[folderID] = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		listed[folderID] = true
+
+		for _, obj := range objects {
+			if !obj.IsFolder {
+				files[obj.ID] = obj
+			} else if recursive && !listed[obj.ID] {
+				if err := list(obj.ID, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, obj := range top {
+		if !obj.IsFolder || !strings.HasPrefix(obj.Name, entry.DailyPrefix) {
+			continue
+		}
+		if err := list(obj.ID, true); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, folderID := range folders {
+		if listed[folderID] {
+			continue
+		}
+		if err := list(folderID, false); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return files, listed, nil
+}
+
+// recordFolders return the storage folder of the records of the entry on the target, without duplicate
+func recordFolders(entry *Entry, target *Target, first bool, records []models.Records) []string {
+	seen := make(map[string]bool)
+	for _, r := range records {
+		ownTarget := r.Target == target.Name || (r.Target == "" && first)
+		if !ownTarget || (r.Watch != entry.Path && r.Watch != "") || r.FolderID == "" {
+			continue
+		}
+		seen[r.FolderID] = true
+	}
+
+	return sortedKeys(seen)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (w *Watcher) forgetRecord(id int) error {
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := w.records.Delete(tx, id); err != nil {
+		return fmt.Errorf("Error Delete Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return nil
+}
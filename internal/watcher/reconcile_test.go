@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// listStorage is the fake storage with List, the daily folders are not under the base folder like with a daily
+// template without the prefix
+type listStorage struct {
+	*fakeStorage
+	mu      sync.Mutex
+	folders map[string][]*storage.Object
+}
+
+func (s *listStorage) Put(ctx context.Context, folderID, name, localPath, mimeType string) (*storage.Object, error) {
+	obj, err := s.fakeStorage.Put(ctx, folderID, name, localPath, mimeType)
+	if err == nil {
+		s.mu.Lock()
+		s.folders[folderID] = append(s.folders[folderID], obj)
+		s.mu.Unlock()
+	}
+	return obj, err
+}
+
+func (s *listStorage) List(ctx context.Context, folderID string) ([]*storage.Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if folderID == "base" {
+		return nil, nil
+	}
+	return s.folders[folderID], nil
+}
+
+func (s *listStorage) drop(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for folder, objects := range s.folders {
+		for i, obj := range objects {
+			if obj.Name == name {
+				s.folders[folder] = append(objects[:i], objects[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func remoteMissing(report *ReconcileReport) []string {
+	var paths []string
+	for _, d := range report.Drifts {
+		if d.Kind == DriftRemoteMissing {
+			paths = append(paths, d.Path)
+		}
+	}
+	return paths
+}
+
+func TestReconcileFolderOfRecords(t *testing.T) {
+	store := &listStorage{fakeStorage: &fakeStorage{}, folders: make(map[string][]*storage.Object)}
+	w := newTestWatcher(t, store, 1, "a.png", "b.png")
+	runQueue(t, w)
+
+	// the daily folder is not found by its prefix, the files are still found on the folder of their record
+	report, err := w.Reconcile(context.Background(), ReconcileOptions{Full: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := remoteMissing(report); len(missing) != 0 {
+		t.Fatalf("missing on remote %v, want none", missing)
+	}
+
+	store.drop("b.png")
+	if report, err = w.Reconcile(context.Background(), ReconcileOptions{Full: true, DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if missing := remoteMissing(report); len(missing) != 1 || !strings.HasSuffix(missing[0], "b.png") {
+		t.Fatalf("missing on remote %v, want b.png", missing)
+	}
+}
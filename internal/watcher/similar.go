@@ -0,0 +1,267 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/checksum"
+	"github.com/momokii/ss-watcher/pkg/imagehash"
+)
+
+// policy for the screenshot that look like one taken just before, see Options.Similar
+const (
+	// no image hash
+	SimilarNone = "none"
+	// uploaded, the record point to the first screenshot
+	SimilarTag = "tag"
+	// uploaded to a folder next to the first screenshot, all the near duplicates go there
+	SimilarGroup = "group"
+	// not uploaded, only a similar record is stored
+	SimilarSkip = "skip"
+)
+
+// suffix of the folder of the near duplicates, after the name of the first screenshot without extension
+const similarFolderSuffix = "_similar"
+
+// similarity is the image hash of the file and the closest screenshot it look like
+type similarity struct {
+	// hex dhash, empty when it is not a supported image or the policy is none
+	phash   string
+	modTime int64
+	// closest record within the window and the distance, nil when none
+	match    *models.Records
+	distance int
+}
+
+// head return the record id of the first screenshot of the near duplicates
+func (s *similarity) head() int {
+	if s.match.SimilarTo != 0 {
+		return s.match.SimilarTo
+	}
+	return s.match.ID
+}
+
+// findSimilar hash the image and look for the screenshot of the target taken within the window before it that look
+// the most like it. the file that is not an image, or on policy none, has an empty similarity
+func (w *Watcher) findSimilar(entry *Entry, target *Target, rel, filepath, mimeType string) (*similarity, error) {
+	sim := &similarity{}
+	if w.similar == SimilarNone || !strings.HasPrefix(mimeType, "image/") {
+		return sim, nil
+	}
+
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("Error Stat File: %w", err)
+	}
+	sim.modTime = info.ModTime().Unix()
+
+	hash, err := imagehash.File(filepath)
+	if err != nil {
+		// format without decoder (webp, heic) is uploaded without hash
+		if !errors.Is(err, image.ErrFormat) {
+			fmt.Printf("Skip image hash of '%s': %v\n", rel, err)
+		}
+		return sim, nil
+	}
+	sim.phash = hash.String()
+
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// only the screenshots taken before, a later one is the head of its own group like in SimilarClusters
+	window := int64(w.similarWindow / time.Second)
+	candidates, err := w.records.FindByModTime(tx, target.Name, sim.modTime-window, sim.modTime)
+	if err != nil {
+		return nil, fmt.Errorf("Error Find By Mod Time: %v", err)
+	}
+
+	// hash of the candidates by id, to compare with the first screenshot of their group
+	hashes := make(map[int]imagehash.DHash, len(*candidates))
+	for _, c := range *candidates {
+		if other, err := imagehash.Parse(c.PHash); err == nil {
+			hashes[c.ID] = other
+		}
+	}
+
+	for i := range *candidates {
+		c := &(*candidates)[i]
+		// the previous record of the same file
+		if c.Path == rel && (c.Watch == entry.Path || c.Watch == "") {
+			continue
+		}
+
+		other, ok := hashes[c.ID]
+		if !ok {
+			continue
+		}
+
+		// the group also has to look like the first screenshot and be within its window, so a slow change of the
+		// screen does not chain unrelated screenshots together
+		if c.SimilarTo != 0 {
+			head, ok := hashes[c.SimilarTo]
+			if !ok || imagehash.Distance(hash, head) > w.similarDistance {
+				continue
+			}
+		}
+
+		if d := imagehash.Distance(hash, other); d <= w.similarDistance && (sim.match == nil || d < sim.distance) {
+			sim.match = c
+			sim.distance = d
+		}
+	}
+
+	return sim, nil
+}
+
+// storeSimilar record the near duplicate skipped by the policy
+func (w *Watcher) storeSimilar(entry *Entry, target *Target, rel, mimeType string, sum *checksum.Sum, sim *similarity) error {
+	dataFile := models.Records{
+		Name:      path.Base(rel),
+		Date:      time.Now().String(),
+		Watch:     entry.Path,
+		Path:      rel,
+		MimeType:  mimeType,
+		Target:    target.Name,
+		Status:    models.RecordSimilar,
+		Attempts:  1,
+		Size:      sum.Size,
+		MD5:       sum.MD5,
+		SHA256:    sum.SHA256,
+		PHash:     sim.phash,
+		ModTime:   sim.modTime,
+		SimilarTo: sim.head(),
+	}
+
+	if err := w.storeRecord(entry, target, &dataFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("[%s] '%s' look like '%s' (distance %d), not uploaded\n", target.Name, rel, sim.match.Path, sim.distance)
+	return nil
+}
+
+// similarFolder return the folder of the near duplicates of the match. the first screenshot stay where it is and
+// the folder is created next to it, the match already inside the folder give its folder. empty when the match
+// has no uploaded file to group with
+func (w *Watcher) similarFolder(target *Target, sim *similarity) (string, error) {
+	match := sim.match
+	if match.Status != models.RecordUploaded || match.FolderID == "" {
+		return "", nil
+	}
+	if match.SimilarTo != 0 {
+		return match.FolderID, nil
+	}
+
+	lock := w.folderLocks[target.Name]
+	lock.Lock()
+	defer lock.Unlock()
+
+	name := strings.TrimSuffix(match.Name, path.Ext(match.Name)) + similarFolderSuffix
+
+	key := target.Name + "|" + match.FolderID + "/" + name
+	w.foldersMu.Lock()
+	id, ok := w.folders[key]
+	w.foldersMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := target.Storage.EnsureFolder(w.ctx, match.FolderID, name)
+	if err != nil {
+		return "", err
+	}
+
+	w.foldersMu.Lock()
+	w.folders[key] = id
+	w.foldersMu.Unlock()
+
+	return id, nil
+}
+
+// SimilarCluster is a group of screenshots of one target that look alike, in the order they were taken
+type SimilarCluster struct {
+	Target  string
+	Records []models.Records
+	// hash distance of each record to the closest one taken before it on the cluster, 0 for the first
+	Distances []int
+}
+
+// SimilarClusters group the records with an image hash: a screenshot join the cluster of the closest one taken
+// within window before it, when at most distance bits differ from it and from the first screenshot of the cluster,
+// taken within window too. only the clusters of two or more are returned
+func SimilarClusters(records []models.Records, distance int, window time.Duration) []SimilarCluster {
+	type hashed struct {
+		record models.Records
+		hash   imagehash.DHash
+	}
+
+	byTarget := make(map[string][]hashed)
+	for _, record := range records {
+		if record.PHash == "" {
+			continue
+		}
+		hash, err := imagehash.Parse(record.PHash)
+		if err != nil {
+			continue
+		}
+		byTarget[record.Target] = append(byTarget[record.Target], hashed{record: record, hash: hash})
+	}
+
+	var clusters []SimilarCluster
+	for _, target := range sortedKeys(byTarget) {
+		items := byTarget[target]
+		sort.SliceStable(items, func(i, j int) bool { return items[i].record.ModTime < items[j].record.ModTime })
+
+		seconds := int64(window / time.Second)
+
+		// cluster index of every item, and item index of the first screenshot of every cluster
+		member := make([]int, len(items))
+		var heads []int
+		var found []SimilarCluster
+		for i, item := range items {
+			best, bestDistance := -1, 0
+			for j := i - 1; j >= 0 && item.record.ModTime-items[j].record.ModTime <= seconds; j-- {
+				d := imagehash.Distance(item.hash, items[j].hash)
+				if d > distance || (best >= 0 && d >= bestDistance) {
+					continue
+				}
+
+				head := items[heads[member[j]]]
+				if item.record.ModTime-head.record.ModTime > seconds || imagehash.Distance(item.hash, head.hash) > distance {
+					continue
+				}
+				best, bestDistance = j, d
+			}
+
+			if best < 0 {
+				member[i] = len(found)
+				heads = append(heads, i)
+				found = append(found, SimilarCluster{Target: target, Records: []models.Records{item.record}, Distances: []int{0}})
+				continue
+			}
+
+			member[i] = member[best]
+			c := &found[member[i]]
+			c.Records = append(c.Records, item.record)
+			c.Distances = append(c.Distances, bestDistance)
+		}
+
+		for _, c := range found {
+			if len(c.Records) > 1 {
+				clusters = append(clusters, c)
+			}
+		}
+	}
+
+	return clusters
+}
@@ -0,0 +1,110 @@
+package watcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/imagehash"
+)
+
+// shot return the record of a screenshot taken at second at, its hash has the low bits set
+func shot(id int, target string, at int64, bits uint) models.Records {
+	return models.Records{ID: id, Target: target, ModTime: 1_700_000_000 + at, PHash: imagehash.DHash(1<<bits - 1).String()}
+}
+
+func TestSimilarClusters(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []models.Records
+		// record ids of every cluster, in order
+		want [][]int
+	}{
+		{
+			name:    "within distance and window",
+			records: []models.Records{shot(1, "drive", 0, 0), shot(2, "drive", 30, 3)},
+			want:    [][]int{{1, 2}},
+		},
+		{
+			name:    "over distance",
+			records: []models.Records{shot(1, "drive", 0, 0), shot(2, "drive", 30, 6)},
+		},
+		{
+			name:    "outside window",
+			records: []models.Records{shot(1, "drive", 0, 0), shot(2, "drive", 121, 0)},
+		},
+		{
+			name:    "taken in any order",
+			records: []models.Records{shot(2, "drive", 60, 1), shot(1, "drive", 0, 0)},
+			want:    [][]int{{1, 2}},
+		},
+		{
+			name:    "other target",
+			records: []models.Records{shot(1, "drive", 0, 0), shot(2, "nas", 10, 0)},
+		},
+		{
+			name: "without or with invalid hash",
+			records: []models.Records{
+				shot(1, "drive", 0, 0),
+				{ID: 2, Target: "drive", ModTime: 1_700_000_010},
+				{ID: 3, Target: "drive", ModTime: 1_700_000_020, PHash: "not a hash"},
+			},
+		},
+		{
+			// 3 look like 2 but not like the first screenshot, a slow change does not chain them
+			name:    "no chain by distance",
+			records: []models.Records{shot(1, "drive", 0, 0), shot(2, "drive", 10, 4), shot(3, "drive", 20, 7)},
+			want:    [][]int{{1, 2}},
+		},
+		{
+			// 3 is within the window of 2 but not of the first screenshot
+			name:    "no chain by window",
+			records: []models.Records{shot(1, "drive", 0, 0), shot(2, "drive", 100, 0), shot(3, "drive", 200, 0)},
+			want:    [][]int{{1, 2}},
+		},
+		{
+			name: "separate series",
+			records: []models.Records{
+				shot(1, "drive", 0, 0), shot(2, "drive", 10, 1),
+				shot(3, "drive", 20, 40), shot(4, "drive", 30, 41),
+			},
+			want: [][]int{{1, 2}, {3, 4}},
+		},
+		{
+			name: "every target",
+			records: []models.Records{
+				shot(1, "nas", 0, 0), shot(2, "nas", 10, 0),
+				shot(3, "drive", 0, 0), shot(4, "drive", 10, 0),
+			},
+			want: [][]int{{3, 4}, {1, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int
+			for _, c := range SimilarClusters(tt.records, 5, 2*time.Minute) {
+				var ids []int
+				for _, r := range c.Records {
+					ids = append(ids, r.ID)
+				}
+				got = append(got, ids)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SimilarClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimilarClustersDistances(t *testing.T) {
+	// 3 is closer to 2 than to 1
+	records := []models.Records{shot(1, "drive", 0, 0), shot(2, "drive", 10, 2), shot(3, "drive", 20, 3)}
+
+	clusters := SimilarClusters(records, 5, 2*time.Minute)
+	if len(clusters) != 1 || !reflect.DeepEqual(clusters[0].Distances, []int{0, 2, 1}) {
+		t.Fatalf("SimilarClusters() = %+v, want distances [0 2 1]", clusters)
+	}
+}
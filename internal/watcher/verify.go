@@ -0,0 +1,244 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// kind of the problem found by Verify
+const (
+	// the uploaded file is gone from the storage
+	ProblemMissing = "missing"
+	// the uploaded file is in the trash of the storage
+	ProblemTrashed = "trashed"
+	// size or md5 of the storage is not the one of the upload
+	ProblemCorrupted = "corrupted"
+	// the uploaded file is not in the folder of its record anymore
+	ProblemMisplaced = "misplaced"
+	// the storage could not be asked, the file is not checked
+	ProblemUnchecked = "unchecked"
+)
+
+// VerifyOptions of one Verify run
+type VerifyOptions struct {
+	// only check the records of this target, empty check all of them
+	Target string
+	// queue the upload of the missing, trashed and corrupted files from their local copy, and move the misplaced
+	// files back to their folder. without it only report
+	Repair bool
+}
+
+// Problem is one uploaded file that is not on the storage as its record say
+type Problem struct {
+	Kind   string
+	Watch  string
+	Path   string
+	Target string
+	// id of the file on the storage from the record
+	RemoteID string
+	// what was found on the storage, ex: the size and md5 of a corrupted file
+	Detail string
+	// what was done on repair, empty without repair
+	Action string
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	// records compared with the storage
+	Checked  int
+	Problems []Problem
+	// records of the watch or target that is not on the config anymore
+	NotConfigured int
+	// id of the upload jobs queued by the repair, see RunJobs
+	Jobs []int
+}
+
+// Verify compare every uploaded record with the metadata of its file on the storage (existence, trash, size, md5
+// and parent folder) and report the differences. on repair the file is uploaded again from the local copy, the
+// problem without local copy is only reported
+func (w *Watcher) Verify(ctx context.Context, opts VerifyOptions) (*VerifyReport, error) {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	records, err := w.records.FindAll(tx)
+	tx.Rollback()
+	if err != nil {
+		return nil, fmt.Errorf("Error Find All Records: %v", err)
+	}
+
+	report := &VerifyReport{}
+
+	for i := range *records {
+		record := &(*records)[i]
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		// duplicate without shortcut and skipped near duplicate have nothing on the storage
+		if record.Status != models.RecordUploaded && (record.Status != models.RecordDuplicate || record.ItemID == "") {
+			continue
+		}
+
+		entry, target := w.recordTarget(record)
+		if target == nil {
+			report.NotConfigured++
+			continue
+		}
+		if opts.Target != "" && target.Name != opts.Target {
+			continue
+		}
+
+		report.Checked++
+		w.verifyRecord(ctx, report, opts, entry, target, record)
+	}
+
+	return report, nil
+}
+
+// verifyRecord compare one record with its file on the storage
+func (w *Watcher) verifyRecord(ctx context.Context, report *VerifyReport, opts VerifyOptions, entry *Entry, target *Target, record *models.Records) {
+	problem := func(kind, detail string) *Problem {
+		report.Problems = append(report.Problems, Problem{Kind: kind, Watch: entry.Path, Path: record.Path, Target: target.Name, RemoteID: record.ItemID, Detail: detail})
+		fmt.Printf("[%s] Verify '%s' %s %s\n", target.Name, record.Path, kind, detail)
+		return &report.Problems[len(report.Problems)-1]
+	}
+
+	// run the repair, the error is kept on the action
+	repair := func(p *Problem, action string, fn func() error) {
+		if !opts.Repair {
+			return
+		}
+		p.Action = action
+		if err := fn(); err != nil {
+			p.Action += " (failed: " + err.Error() + ")"
+			fmt.Printf("[%s] Error Repair '%s': %v\n", target.Name, record.Path, err)
+		}
+	}
+
+	obj, err := target.Storage.Stat(ctx, record.ItemID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		p := problem(ProblemMissing, "")
+		repair(p, "upload again", func() error { return w.reupload(report, entry, target, record, true) })
+
+	case err != nil:
+		problem(ProblemUnchecked, err.Error())
+
+	case obj.Trashed:
+		p := problem(ProblemTrashed, "")
+		repair(p, "upload again", func() error { return w.reupload(report, entry, target, record, true) })
+
+	case record.Status == models.RecordUploaded && corrupted(record, obj):
+		p := problem(ProblemCorrupted, fmt.Sprintf("size %d md5 %s, uploaded size %d md5 %s", obj.Size, obj.MD5, record.Size, record.MD5))
+		repair(p, "upload again", func() error { return w.reupload(report, entry, target, record, false) })
+
+	case record.FolderID != "" && obj.ParentID != "" && strings.TrimSuffix(obj.ParentID, "/") != strings.TrimSuffix(record.FolderID, "/"):
+		p := problem(ProblemMisplaced, fmt.Sprintf("in folder %s, uploaded to %s", obj.ParentID, record.FolderID))
+		repair(p, "move back", func() error { return w.moveBack(ctx, target, record) })
+	}
+}
+
+// corrupted report if the size or md5 of the storage is not the one stored on the record. records without hashes
+// (before they were stored) and storage without md5 only compare what they have
+func corrupted(record *models.Records, obj *storage.Object) bool {
+	if obj.IsFolder {
+		return false
+	}
+	if record.Size != 0 && obj.Size != record.Size {
+		return true
+	}
+	return record.MD5 != "" && obj.MD5 != "" && obj.MD5 != record.MD5
+}
+
+// reupload queue the upload of the local copy of the record and add the job to the report. with forget the record
+// is forgotten so the file is uploaded as a new one, without it the upload replace the content of the same file. the
+// record keep its hashes until the upload store the new ones, so a failed or stopped upload is still reported
+func (w *Watcher) reupload(report *VerifyReport, entry *Entry, target *Target, record *models.Records, forget bool) error {
+	localPath := filepath.Join(entry.Path, filepath.FromSlash(record.Path))
+	if info, err := os.Stat(localPath); errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return fmt.Errorf("no local copy")
+	} else if err != nil {
+		return fmt.Errorf("Error Stat File: %v", err)
+	}
+
+	kind := models.JobReupload
+	if forget {
+		if err := w.forgetRecord(record.ID); err != nil {
+			return err
+		}
+		kind = models.JobUpload
+	}
+
+	ids, err := w.enqueueJobs(entry, []Target{*target}, models.Job{Kind: kind, Path: record.Path})
+	if err != nil {
+		return err
+	}
+
+	report.Jobs = append(report.Jobs, ids...)
+	return nil
+}
+
+// moveBack move the file of the record back to the folder and name of the record
+func (w *Watcher) moveBack(ctx context.Context, target *Target, record *models.Records) error {
+	mover, ok := target.Storage.(storage.Mover)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	obj, err := mover.Move(ctx, record.ItemID, record.FolderID, record.Name)
+	if err != nil {
+		return fmt.Errorf("Error Move File: %w", err)
+	}
+
+	if obj.ID == record.ItemID {
+		return nil
+	}
+
+	record.ItemID = obj.ID
+
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := w.records.Update(tx, record); err != nil {
+		return fmt.Errorf("Error Store Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return nil
+}
+
+// recordTarget return the entry and target of the record, records without watch or target are from the time there
+// was one watch and one target so they belong to the first ones. nil when the watch or target is not configured
+func (w *Watcher) recordTarget(record *models.Records) (*Entry, *Target) {
+	watch := record.Watch
+	if watch == "" && len(w.entries) > 0 {
+		watch = w.entries[0].Path
+	}
+
+	if record.Target != "" {
+		return w.targetFor(watch, record.Target)
+	}
+
+	for i := range w.entries {
+		if w.entries[i].Path == watch && len(w.entries[i].Targets) > 0 {
+			return &w.entries[i], &w.entries[i].Targets[0]
+		}
+	}
+
+	return nil, nil
+}
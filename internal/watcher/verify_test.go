@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/momokii/ss-watcher/internal/models"
+)
+
+func TestVerifyRepairCorrupted(t *testing.T) {
+	ctx := context.Background()
+	w, _ := newMoveWatcher(t)
+	original := upload(t, w, "a.png", "screenshot")
+
+	// changed on the storage, and another upload waiting on the queue
+	if err := os.WriteFile(original.ItemID, []byte("broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeLocal(t, w, "b.png", "other screenshot")
+	w.queueUpload(&w.entries[0], "b.png", filepath.Join(w.entries[0].Path, "b.png"))
+
+	report, err := w.Verify(ctx, VerifyOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemCorrupted || len(report.Jobs) != 1 {
+		t.Fatalf("Verify() = %+v, want a.png corrupted with one repair job", report)
+	}
+
+	// the upload did not run yet: the record keep its hashes and the file is still reported
+	if record := localRecord(t, w, "a.png"); record.SHA256 != original.SHA256 || record.MD5 != original.MD5 || record.Size != original.Size {
+		t.Fatalf("record before the upload = %+v, want the hashes kept", record)
+	}
+	again, err := w.Verify(ctx, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Problems) != 1 || again.Problems[0].Kind != ProblemCorrupted {
+		t.Fatalf("Verify() before the upload = %+v, want a.png still corrupted", again.Problems)
+	}
+
+	w.RunJobs(ctx, report.Jobs)
+	w.bind(ctx)
+
+	// the same content as the record is uploaded anyway
+	if data, err := os.ReadFile(original.ItemID); err != nil || string(data) != "screenshot" {
+		t.Fatalf("repaired file = %q, %v", data, err)
+	}
+	if record := localRecord(t, w, "a.png"); record.ID != original.ID || record.SHA256 != original.SHA256 {
+		t.Fatalf("record after the upload = %+v", record)
+	}
+	if after, err := w.Verify(ctx, VerifyOptions{}); err != nil || len(after.Problems) != 0 {
+		t.Fatalf("Verify() after the repair = %+v, %v, want no problem", after, err)
+	}
+
+	// the other job is left to the watcher
+	if localRecord(t, w, "b.png") != nil {
+		t.Fatal("b.png uploaded by the repair")
+	}
+	if jobs := queuedJobs(t, w); len(jobs) != 1 || jobs[0].Kind != models.JobUpload || jobs[0].Path != "b.png" {
+		t.Fatalf("queued %+v, want only the upload of b.png", jobs)
+	}
+}
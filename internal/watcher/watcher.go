@@ -0,0 +1,1211 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/internal/repository"
+	"github.com/momokii/ss-watcher/internal/rules"
+	"github.com/momokii/ss-watcher/pkg/checksum"
+	"github.com/momokii/ss-watcher/pkg/mimetype"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// Entry is one watched local folder and where its files go on the storage
+type Entry struct {
+	// absolute path of the local folder, also used as the watch key on the records table
+	Path string
+	// every file is uploaded to all of them, each one has its own record
+	Targets     []Target
+	DailyPrefix string
+	// nil allow every file
+	Rules *rules.Rules
+}
+
+// Target is one storage of the entry
+type Target struct {
+	// name of the target from the config, stored on the records table
+	Name         string
+	Storage      storage.Storage
+	BaseFolderID string
+}
+
+// Options of the watcher, zero value use the defaults
+type Options struct {
+	// how long a file must stay unchanged before uploaded
+	DebounceWindow time.Duration
+	// time of the debounce window and of the rename pairing, nil use the real time
+	Clock Clock
+	// mime type by extension, used instead of the detected one
+	MimeTypes map[string]string
+	// how long a failed job wait on the queue before it is tried again
+	RetryInterval time.Duration
+	// how many jobs run at the same time, default 4
+	Workers int
+	// how long the running jobs can finish on stop before they are canceled, default 30s
+	DrainTimeout time.Duration
+	// run a full Reconcile when the watcher start, with this orphan policy
+	SyncOnStart bool
+	Orphans     string
+	// DedupeSkip, DedupeLink or DedupeUpload, empty is DedupeSkip
+	Dedupe string
+	// SimilarNone, SimilarTag, SimilarGroup or SimilarSkip, empty is SimilarNone. the screenshots taken within
+	// SimilarWindow with at most SimilarDistance different bits of the image hash are near duplicates
+	Similar         string
+	SimilarDistance int
+	SimilarWindow   time.Duration
+}
+
+type Watcher struct {
+	// context of the storage calls and the database, canceled only when the drain timeout is over
+	ctx       context.Context
+	cancel    context.CancelFunc
+	db        *sql.DB
+	records   repository.RecordRepository
+	jobs      repository.JobRepository
+	entries   []Entry
+	fsw       *fsnotify.Watcher
+	debouncer *Debouncer
+	tick      time.Duration
+	retry     time.Duration
+	workers   int
+	drain     time.Duration
+	mimeTypes map[string]string
+	dedupe    string
+	similar   string
+	startSync *ReconcileOptions
+	// how long the delete of a removed file wait on the queue, so a move seen as remove and create can be paired
+	// by content when the new file is uploaded
+	moveHold time.Duration
+	// last Rename event, paired with the Create event of the new name that follow it
+	renamed *renamedFile
+	clock   Clock
+	// max hash distance and capture time window of the near duplicates
+	similarDistance int
+	similarWindow   time.Duration
+
+	// signal the worker that a job was queued
+	wake chan struct{}
+	// closed when the worker stopped and the running jobs are done
+	drained chan struct{}
+
+	// storage folder id of the mirrored subfolders, key is <target>|<daily folder id>/<relative dir>
+	folders map[string]string
+	// one lock per target, two workers must not create the same daily folder or subfolder twice
+	folderLocks map[string]*sync.Mutex
+	foldersMu   sync.Mutex
+}
+
+func New(db *sql.DB, entries []Entry, opts Options) *Watcher {
+	if opts.DebounceWindow <= 0 {
+		opts.DebounceWindow = 2 * time.Second
+	}
+
+	// check the pending files a few times per window, but not too often for long window
+	tick := opts.DebounceWindow / 4
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	} else if tick > time.Second {
+		tick = time.Second
+	}
+
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Minute
+	}
+
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 30 * time.Second
+	}
+
+	if opts.Dedupe == "" {
+		opts.Dedupe = DedupeSkip
+	}
+
+	if opts.Similar == "" {
+		opts.Similar = SimilarNone
+	}
+
+	if opts.SimilarWindow <= 0 {
+		opts.SimilarWindow = 2 * time.Minute
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+
+	folderLocks := make(map[string]*sync.Mutex)
+	for _, entry := range entries {
+		for _, target := range entry.Targets {
+			folderLocks[target.Name] = &sync.Mutex{}
+		}
+	}
+
+	var startSync *ReconcileOptions
+	if opts.SyncOnStart {
+		startSync = &ReconcileOptions{Full: true, Orphans: opts.Orphans}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Watcher{
+		ctx:       ctx,
+		cancel:    cancel,
+		db:        db,
+		records:   repository.NewRecordsRepository(),
+		jobs:      repository.NewJobsRepository(),
+		entries:   entries,
+		debouncer: NewDebouncer(opts.Clock, opts.DebounceWindow),
+		tick:      tick,
+		retry:     opts.RetryInterval,
+		workers:   opts.Workers,
+		drain:     opts.DrainTimeout,
+		mimeTypes: opts.MimeTypes,
+		dedupe:    opts.Dedupe,
+		similar:   opts.Similar,
+		startSync: startSync,
+		moveHold:  2*opts.DebounceWindow + 2*time.Second,
+		clock:     opts.Clock,
+		wake:      make(chan struct{}, 1),
+		drained:   make(chan struct{}),
+		folders:   make(map[string]string),
+
+		similarDistance: opts.SimilarDistance,
+		similarWindow:   opts.SimilarWindow,
+		folderLocks:     folderLocks,
+	}
+}
+
+// bind use ctx for the storage calls and the database, its values are kept but not its cancel:
+// on stop the running jobs get the drain timeout before they are canceled with w.cancel
+func (w *Watcher) bind(ctx context.Context) {
+	w.ctx, w.cancel = context.WithCancel(context.WithoutCancel(ctx))
+}
+
+// Run watch all the entries (and all their subfolders) with one fsnotify watcher and block until ctx is canceled
+// or the watcher is closed. on stop the running jobs are finished (or canceled after the drain timeout) and the
+// files still written are queued for the next start
+func (w *Watcher) Run(ctx context.Context) error {
+	w.bind(ctx)
+	defer w.cancel()
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsw.Close()
+	w.fsw = fsw
+
+	for _, entry := range w.entries {
+		if err := w.addRecursive(entry.Path, nil); err != nil {
+			return fmt.Errorf("Error Watch '%s': %v", entry.Path, err)
+		}
+		fmt.Println("Watching: " + entry.Path)
+	}
+
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	// uploads and deletes run on the worker from the queue, so the events are not blocked by a slow or offline target
+	stop, stopWork := context.WithCancel(ctx)
+	go w.work(stop)
+	defer func() {
+		stopWork()
+		<-w.drained
+		w.printQueued()
+	}()
+
+	fmt.Println("\nWaiting for event...")
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("\nShutting down, press Ctrl-C again to force...")
+			w.expireRename(true)
+
+			// not stable yet, the upload on the next start read the file as it is then
+			for _, path := range w.debouncer.Flush() {
+				if entry, rel := w.entryFor(path); entry != nil {
+					w.queueUpload(entry, rel, path)
+				}
+			}
+			return nil
+
+		case <-ticker.C:
+			w.expireRename(false)
+
+			// upload the files that are done being written
+			for _, path := range w.debouncer.Ready() {
+				if entry, rel := w.entryFor(path); entry != nil {
+					w.queueUpload(entry, rel, path)
+				}
+			}
+
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			fmt.Println("Event: ", event)
+
+			entry, rel := w.entryFor(event.Name)
+			renamed := w.pairRename(entry, event)
+			if entry == nil {
+				fmt.Println("No watch entry for: ", event.Name)
+				continue
+			}
+
+			w.handleEvent(entry, rel, event, renamed)
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Println("Error: ", err)
+		}
+	}
+}
+
+// addRecursive add fsnotify watch for root and every folder inside it, onFile is called for every file found
+func (w *Watcher) addRecursive(root string, onFile func(path string)) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable subfolder should not stop the whole watch
+			if path != root {
+				fmt.Println("Error Walk: ", err)
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if d.IsDir() {
+			return w.fsw.Add(path)
+		}
+
+		if onFile != nil {
+			onFile(path)
+		}
+		return nil
+	})
+}
+
+// entryFor find the entry that own the path and return it with the slash separated path relative to the entry folder
+func (w *Watcher) entryFor(path string) (*Entry, string) {
+	for i := range w.entries {
+		rel, err := filepath.Rel(w.entries[i].Path, path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		return &w.entries[i], filepath.ToSlash(rel)
+	}
+
+	return nil, ""
+}
+
+// handleEvent process the event of the entry, renamed is the old name when the event is the Create of a rename
+func (w *Watcher) handleEvent(entry *Entry, rel string, event fsnotify.Event, renamed *renamedFile) {
+	filepath := event.Name
+
+	// ! --- WATCHER NEW FOLDER PROCESS
+	// new folder need its own watch, and files already inside it (moved or copied folder) never get write event
+	if event.Op&fsnotify.Create == fsnotify.Create {
+		if info, err := os.Stat(filepath); err == nil && info.IsDir() {
+			fmt.Println("New folder: ", filepath)
+
+			// renamed folder, its uploaded files are moved on the storage
+			onFile := w.debouncer.Touch
+			if renamed != nil {
+				onFile = func(path string) {
+					if _, fileRel := w.entryFor(path); fileRel != "" {
+						w.queueMove(entry, renamed.rel+strings.TrimPrefix(fileRel, rel), fileRel, path)
+					}
+				}
+			}
+
+			if err := w.addRecursive(filepath, onFile); err != nil {
+				fmt.Println("Error Watch Folder: ", err)
+			}
+			return
+		}
+
+		// ! --- WATCHER RENAME/MOVE FILE PROCESS
+		if renamed != nil {
+			w.queueMove(entry, renamed.rel, rel, filepath)
+			return
+		}
+	}
+
+	// ! --- WATCHER UPLOAD/NEW EVENT FILE PROCESS
+	// the file is uploaded once it is stable, one screenshot can be written with several write events
+	if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+		w.debouncer.Touch(filepath)
+
+		// ! --- WATCHER DELETE EVENT FILE PROCESS
+	} else if event.Op&fsnotify.Remove == fsnotify.Remove {
+		fmt.Println("Remove file: ", filepath)
+		w.debouncer.Cancel(filepath)
+		w.queueRemove(entry, rel)
+
+	} else if event.Op&fsnotify.Rename == fsnotify.Rename {
+		// a moved watched folder also report itself with the new name, the old name of a real rename is gone
+		if _, err := os.Lstat(filepath); err == nil {
+			return
+		}
+
+		// the new name inside the watch come with a Create event right after, the file is moved on it
+		fmt.Println("Rename file: ", filepath)
+		w.debouncer.Cancel(filepath)
+		w.renamed = &renamedFile{entry: entry, rel: rel, at: w.clock.Now()}
+
+	} else {
+		fmt.Println("File: ", filepath)
+		fmt.Println("Event: ", event)
+	}
+}
+
+// folderFor return the storage folder for the relative file path, the local subfolders are mirrored inside the daily folder
+func (w *Watcher) folderFor(entry *Entry, target *Target, rel string) (string, error) {
+	lock := w.folderLocks[target.Name]
+	lock.Lock()
+	defer lock.Unlock()
+
+	folderId, err := storage.EnsureDailyFolder(w.ctx, target.Storage, target.BaseFolderID, entry.DailyPrefix, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	dir := path.Dir(rel)
+	if dir == "." {
+		return folderId, nil
+	}
+
+	key := target.Name + "|" + folderId + "/" + dir
+	w.foldersMu.Lock()
+	id, ok := w.folders[key]
+	w.foldersMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err = storage.EnsureFolderPath(w.ctx, target.Storage, folderId, dir)
+	if err != nil {
+		return "", err
+	}
+
+	w.foldersMu.Lock()
+	w.folders[key] = id
+	w.foldersMu.Unlock()
+
+	return id, nil
+}
+
+// forgetFolder drop the cached subfolders of the target with the id, and the ones inside it. return false when
+// the id was not cached, so it was not a subfolder created before
+func (w *Watcher) forgetFolder(target *Target, id string) bool {
+	w.foldersMu.Lock()
+	defer w.foldersMu.Unlock()
+
+	found := false
+	for key, cached := range w.folders {
+		if !strings.HasPrefix(key, target.Name+"|") {
+			continue
+		}
+		if cached == id || strings.HasPrefix(key, target.Name+"|"+id+"/") {
+			delete(w.folders, key)
+			found = true
+		}
+	}
+
+	return found
+}
+
+// queueUpload check the file against the rules and queue its upload to every target of the entry
+func (w *Watcher) queueUpload(entry *Entry, rel, filepath string) {
+	info, err := os.Stat(filepath)
+	if err != nil || info.IsDir() {
+		return
+	}
+
+	if result := entry.Rules.Match(rel, info.Size()); !result.Allowed {
+		fmt.Printf("Skip '%s': %s\n", rel, result.Reason)
+		return
+	}
+
+	fmt.Println("Queue upload: ", filepath)
+	w.enqueue(entry, entry.Targets, models.Job{Kind: models.JobUpload, Path: rel})
+}
+
+// queueRemove queue the delete of the file from every target of the entry, it wait for the move hold
+func (w *Watcher) queueRemove(entry *Entry, rel string) {
+	w.enqueue(entry, entry.Targets, models.Job{Kind: models.JobDelete, Path: rel, RunAt: time.Now().Add(w.moveHold).Unix()})
+}
+
+// enqueue store one copy of the job per target, so every target is retried on its own, and wake up the worker.
+// zero RunAt run the job now
+func (w *Watcher) enqueue(entry *Entry, targets []Target, job models.Job) error {
+	_, err := w.enqueueJobs(entry, targets, job)
+	return err
+}
+
+// enqueueJobs is enqueue that return the id of the job of every target
+func (w *Watcher) enqueueJobs(entry *Entry, targets []Target, job models.Job) ([]int, error) {
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		fmt.Println("Error Begin Transaction: ", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	if job.RunAt == 0 {
+		job.RunAt = now
+	}
+	ids := make([]int, 0, len(targets))
+	for _, target := range targets {
+		job.Watch = entry.Path
+		job.Target = target.Name
+		job.CreatedAt = now
+		if err := w.jobs.Enqueue(tx, &job); err != nil {
+			fmt.Println("Error Enqueue Job: ", err)
+			return nil, err
+		}
+		ids = append(ids, job.ID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error Commit: ", err)
+		return nil, err
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+
+	return ids, nil
+}
+
+// * ------------ QUEUE WORKER
+
+// work run the queued jobs until ctx is canceled, jobs left from the previous run are picked up first
+func (w *Watcher) work(ctx context.Context) {
+	defer close(w.drained)
+
+	// the network is often back after a restart, the failed jobs are tried right away instead of after their wait
+	w.runQueuedNow()
+
+	// files created or removed while the watcher was stopped have no event
+	if w.startSync != nil {
+		fmt.Println("Sync local folders with the records and the storage...")
+		if report, err := w.Reconcile(ctx, *w.startSync); err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("Error Sync: ", err)
+			}
+		} else {
+			fmt.Printf("Sync done, %d difference(s) found\n", len(report.Drifts))
+		}
+	}
+
+	w.runPool(ctx.Done(), false, nil)
+}
+
+type jobResult struct {
+	job *models.Job
+	err error
+}
+
+// runPool run the due jobs on w.workers goroutines until done is closed, or with untilIdle until nothing more can run.
+// only not nil run only the jobs with these ids. jobs of the same file and target never run at the same time,
+// FindDue only return the oldest one. on stop the started jobs are drained, the others stay on the queue for the
+// next start
+func (w *Watcher) runPool(done <-chan struct{}, untilIdle bool, only []int) {
+	jobs := make(chan *models.Job, w.workers)
+	results := make(chan jobResult, w.workers)
+	defer close(jobs)
+
+	for i := 0; i < w.workers; i++ {
+		go func() {
+			for job := range jobs {
+				results <- jobResult{job: job, err: w.runJob(job)}
+			}
+		}()
+	}
+
+	// started jobs by id, they stay on the table until done
+	running := make(map[int]bool)
+	// target that failed and until when its jobs are not started, when offline every job of it would fail the same way
+	down := make(map[string]time.Time)
+
+	for {
+		// checked before the dispatch too, a stop never start a new job
+		select {
+		case <-done:
+			w.drainJobs(running, results)
+			return
+		default:
+		}
+
+		started, wait := w.dispatch(jobs, running, down, only)
+		if untilIdle && started == 0 && len(running) == 0 {
+			return
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-done:
+			// drained on the next loop
+		case r := <-results:
+			delete(running, r.job.ID)
+			// only the network or the storage service is a reason to hold the other jobs of the target, the error
+			// of one file (local file, bad name) fail that job alone
+			if r.err != nil && storage.IsUnavailable(r.err) {
+				down[r.job.Target] = time.Now().Add(w.retry)
+			}
+		case <-w.wake:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// drainJobs wait for the running jobs, after the drain timeout they are canceled and stay on the queue for the next start
+func (w *Watcher) drainJobs(running map[int]bool, results <-chan jobResult) {
+	if len(running) == 0 {
+		return
+	}
+	fmt.Printf("Waiting up to %s for %d running job(s)...\n", w.drain, len(running))
+
+	timeout := time.NewTimer(w.drain)
+	defer timeout.Stop()
+
+	for len(running) > 0 {
+		select {
+		case r := <-results:
+			delete(running, r.job.ID)
+		case <-timeout.C:
+			fmt.Printf("Drain timeout, cancel %d running job(s), they run again on the next start\n", len(running))
+			w.cancel()
+		}
+	}
+}
+
+// dispatch start the due jobs (of only when not nil) while a worker is free, return how many were started and how
+// long to wait when no job finish and nothing is queued before
+func (w *Watcher) dispatch(jobs chan<- *models.Job, running map[int]bool, down map[string]time.Time, only []int) (int, time.Duration) {
+	now := time.Now()
+
+	var skip []string
+	var nextUp time.Time
+	for target, until := range down {
+		if !now.Before(until) {
+			delete(down, target)
+			continue
+		}
+		skip = append(skip, target)
+		if nextUp.IsZero() || until.Before(nextUp) {
+			nextUp = until
+		}
+	}
+
+	free := w.workers - len(running)
+	if free <= 0 {
+		// a finished job wake up the pool
+		return 0, w.retry
+	}
+
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		fmt.Println("Error Begin Transaction: ", err)
+		return 0, w.retry
+	}
+	defer tx.Rollback()
+
+	// the running jobs are still due, so ask for enough to fill the free workers
+	due, err := w.jobs.FindDue(tx, now.Unix(), skip, only, len(running)+free)
+	var runAt int64
+	var hasNext bool
+	if err == nil {
+		runAt, hasNext, err = w.jobs.NextRunAt(tx, now.Unix())
+	}
+	if err != nil {
+		fmt.Println("Error Find Due Jobs: ", err)
+		return 0, w.retry
+	}
+
+	// marked as started before a worker get them, a later edit queue a new job and a claimed delete is not run
+	var start []*models.Job
+	for i := range *due {
+		job := &(*due)[i]
+		if running[job.ID] || len(start) == free {
+			continue
+		}
+
+		if ok, err := w.jobs.Start(tx, job.ID, now.Unix()); err != nil {
+			fmt.Println("Error Start Job: ", err)
+			return 0, w.retry
+		} else if ok {
+			start = append(start, job)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error Commit: ", err)
+		return 0, w.retry
+	}
+
+	for _, job := range start {
+		running[job.ID] = true
+		jobs <- job
+	}
+	started := len(start)
+
+	// due jobs that are not started wait for a running job of the same file or for a free worker,
+	// both wake up the pool, so only the future jobs and the failed targets are waited here
+	wait := w.retry
+	if hasNext {
+		wait = min(wait, time.Until(time.Unix(runAt, 0)))
+	}
+	if !nextUp.IsZero() {
+		wait = min(wait, time.Until(nextUp))
+	}
+
+	return started, max(wait, w.tick)
+}
+
+func (w *Watcher) runQueuedNow() {
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		fmt.Println("Error Begin Transaction: ", err)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := w.jobs.RunAllAt(tx, now.Unix(), now.Add(-w.moveHold).Unix()); err != nil {
+		fmt.Println("Error Reschedule Jobs: ", err)
+		return
+	}
+
+	// started by the previous run and stopped before the end
+	if err := w.jobs.ResetStarted(tx); err != nil {
+		fmt.Println("Error Reset Started Jobs: ", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error Commit: ", err)
+	}
+}
+
+// RunQueue run the queued jobs until nothing more can run or ctx is canceled, for the commands that queue jobs
+// without the watcher. the failed jobs are tried right away
+func (w *Watcher) RunQueue(ctx context.Context) {
+	w.bind(ctx)
+	defer w.cancel()
+
+	w.runQueuedNow()
+	w.runPool(ctx.Done(), true, nil)
+}
+
+// RunJobs run the queued jobs with the ids until they are done, failed or wait for another job of their file, or
+// ctx is canceled. the other jobs of the queue are left to the watcher
+func (w *Watcher) RunJobs(ctx context.Context, ids []int) {
+	w.bind(ctx)
+	defer w.cancel()
+
+	if ids == nil {
+		ids = []int{}
+	}
+	w.runPool(ctx.Done(), true, ids)
+}
+
+// printQueued show how many jobs are left for the next start
+func (w *Watcher) printQueued() {
+	tx, err := w.db.Begin()
+	if err != nil {
+		fmt.Println("Error Begin Transaction: ", err)
+		return
+	}
+	defer tx.Rollback()
+
+	jobs, err := w.jobs.FindAll(tx)
+	if err != nil {
+		fmt.Println("Error Find All Jobs: ", err)
+		return
+	}
+	if len(*jobs) > 0 {
+		fmt.Printf("%d job(s) left on the queue, they run on the next start\n", len(*jobs))
+	}
+}
+
+// runJob run the job and remove it from the queue, failed job stay on the queue and run again after the retry interval.
+// permanent error is not retried, the record keep it
+func (w *Watcher) runJob(job *models.Job) error {
+	entry, target := w.targetFor(job.Watch, job.Target)
+
+	var err error
+	switch {
+	case target == nil:
+		// watch or target removed from the config, keep the job for when it is back
+		err = fmt.Errorf("target '%s' of '%s' is not configured", job.Target, job.Watch)
+	case job.Kind == models.JobDelete:
+		err = w.removeFrom(entry, target, target == &entry.Targets[0], job.Path)
+	case job.Kind == models.JobMove:
+		err = w.moveFile(entry, target, target == &entry.Targets[0], job)
+	default:
+		err = w.uploadFile(entry, target, job)
+	}
+
+	// canceled on stop, the job stay as it is and run again on the next start
+	if err != nil && w.ctx.Err() != nil {
+		return err
+	}
+
+	// the result of a finished storage call is stored even when the drain timeout is over meanwhile
+	tx, txErr := w.db.BeginTx(context.WithoutCancel(w.ctx), nil)
+	if txErr != nil {
+		fmt.Println("Error Begin Transaction: ", txErr)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err == nil || errors.Is(err, storage.ErrPermanent) {
+		txErr = w.jobs.Delete(tx, job.ID)
+	} else {
+		job.Attempts++
+		job.Error = err.Error()
+		job.RunAt = time.Now().Add(w.retry).Unix()
+		txErr = w.jobs.Reschedule(tx, job)
+	}
+	if txErr != nil {
+		fmt.Println("Error Store Job: ", txErr)
+		return err
+	}
+
+	if txErr := tx.Commit(); txErr != nil {
+		fmt.Println("Error Commit: ", txErr)
+	}
+
+	return err
+}
+
+// uploadFile run the upload job, the file removed before it is uploaded is skipped (its delete job is queued after it)
+func (w *Watcher) uploadFile(entry *Entry, target *Target, job *models.Job) error {
+	localPath := filepath.Join(entry.Path, filepath.FromSlash(job.Path))
+
+	info, err := os.Stat(localPath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		fmt.Println("Skip upload, local file removed: ", localPath)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Error Stat File: %w", err)
+	}
+
+	// a moved file seen as remove and create is renamed on the storage instead of uploaded again, the file to
+	// upload again already has its record
+	if job.Kind != models.JobReupload {
+		if moved, err := w.moveMatching(entry, target, target == &entry.Targets[0], job.Path, localPath, info.Size()); moved || err != nil {
+			return err
+		}
+	}
+
+	if job.Attempts > 0 {
+		fmt.Printf("Retry upload '%s' to %s (attempt %d)\n", localPath, target.Name, job.Attempts+1)
+	} else {
+		fmt.Println("Upload file: ", localPath)
+	}
+
+	mimeType, err := mimetype.Detect(localPath, w.mimeTypes)
+	if err != nil {
+		return fmt.Errorf("Error Detect Mime Type: %w", err)
+	}
+
+	return w.uploadTo(entry, target, job.Path, localPath, mimeType, job.Kind == models.JobReupload)
+}
+
+// uploadTo upload the file to one target and store the result, failed upload is stored too with its error.
+// the previous failed upload of the same file is replaced by the result, an uploaded one is updated. the file saved
+// again without change is skipped, and the content already uploaded to the target follow the dedupe policy.
+// the upload error is returned. force replace the uploaded file even when the record has the same content
+func (w *Watcher) uploadTo(entry *Entry, target *Target, rel, filepath, mimeType string, force bool) error {
+	sum, err := checksum.File(filepath)
+	if err != nil {
+		return fmt.Errorf("Error Hash File: %w", err)
+	}
+
+	previous, err := w.findRecord(entry, target, rel)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.Target == "" && target != &entry.Targets[0] {
+		previous = nil
+	}
+
+	if previous != nil && !force {
+		if same, err := w.sameContent(target, previous, sum); err != nil {
+			return err
+		} else if same {
+			fmt.Printf("[%s] '%s' is unchanged, skip upload\n", target.Name, rel)
+			return nil
+		}
+	}
+
+	sim, err := w.findSimilar(entry, target, rel, filepath, mimeType)
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		switch previous.Status {
+		case models.RecordUploaded:
+			// edited file, one local file keep one file on the storage. the duplicates keep the uploaded content,
+			// so the edited file is uploaded as a new file instead
+			if kept, err := w.handOverRecord(target, previous); err != nil {
+				return err
+			} else if !kept {
+				return w.updateTo(entry, target, previous, filepath, mimeType, sum, sim)
+			}
+		case models.RecordDuplicate:
+			// edited copy, it is not a duplicate anymore
+			if err := w.dropShortcut(target, previous); err != nil {
+				return err
+			}
+		}
+	}
+
+	if w.dedupe != DedupeUpload {
+		if original, err := w.findOriginal(target, sum); err != nil {
+			return err
+		} else if original != nil {
+			return w.storeDuplicate(entry, target, rel, filepath, mimeType, sum, original)
+		}
+	}
+
+	// near duplicate of a screenshot taken just before
+	if sim.match != nil && w.similar == SimilarSkip {
+		return w.storeSimilar(entry, target, rel, mimeType, sum, sim)
+	} else if sim.match != nil {
+		fmt.Printf("[%s] '%s' look like '%s' (distance %d)\n", target.Name, rel, sim.match.Path, sim.distance)
+	}
+
+	return w.putFile(entry, target, rel, filepath, mimeType, sum, sim)
+}
+
+// putFile upload the file as a new file on the target and store the record, see uploadTo. the near duplicate
+// go to the folder of its group on the group policy
+func (w *Watcher) putFile(entry *Entry, target *Target, rel, filepath, mimeType string, sum *checksum.Sum, sim *similarity) error {
+	filename := path.Base(rel)
+
+	dataFile := models.Records{
+		Name:     filename,
+		Date:     time.Now().String(),
+		Watch:    entry.Path,
+		Path:     rel,
+		MimeType: mimeType,
+		Target:   target.Name,
+		Status:   models.RecordUploaded,
+		Attempts: 1,
+		Size:     sum.Size,
+		MD5:      sum.MD5,
+		SHA256:   sum.SHA256,
+		PHash:    sim.phash,
+		ModTime:  sim.modTime,
+	}
+	if sim.match != nil {
+		dataFile.SimilarTo = sim.head()
+	}
+
+	folderId, fileUpload, uploadErr := w.putObject(entry, target, rel, filepath, mimeType, sim)
+	if errors.Is(uploadErr, storage.ErrNotFound) && w.forgetFolder(target, folderId) {
+		// the cached folder was removed from the storage, it is created again
+		fmt.Printf("[%s] Folder %s is gone from the storage, create it again\n", target.Name, folderId)
+		folderId, fileUpload, uploadErr = w.putObject(entry, target, rel, filepath, mimeType, sim)
+	}
+	if uploadErr == nil {
+		if fileUpload.MD5 != "" && fileUpload.MD5 != sum.MD5 {
+			// changed while uploading or corrupted on the way, the bad copy is removed and the retry upload it again
+			uploadErr = fmt.Errorf("Error Upload File: checksum mismatch, local md5 %s, uploaded %s", sum.MD5, fileUpload.MD5)
+			if err := target.Storage.Delete(w.ctx, fileUpload.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				fmt.Printf("[%s] Error Delete File: %v\n", target.Name, err)
+			}
+		} else {
+			dataFile.ItemID = fileUpload.ID
+			dataFile.FolderID = folderId
+			// a resumed upload continue in the folder it was started in
+			if fileUpload.ParentID != "" {
+				dataFile.FolderID = fileUpload.ParentID
+			}
+			fmt.Printf("Upload File Success to %s ID: %s\n", target.Name, fileUpload.ID)
+
+			// backend without share per email (object storage) can give a link instead
+			if linker, ok := target.Storage.(storage.Linker); ok {
+				if link, err := linker.ShareLink(w.ctx, fileUpload.ID); err == nil {
+					fmt.Println("Share Link: ", link)
+				}
+			}
+		}
+	}
+
+	if uploadErr != nil && w.ctx.Err() != nil {
+		// canceled on stop, not a failure of the file
+		return uploadErr
+	} else if errors.Is(uploadErr, storage.ErrPermanent) {
+		fmt.Printf("[%s] Permanent failure, not retried: %v\n", target.Name, uploadErr)
+		dataFile.Status = models.RecordRejected
+		dataFile.Error = uploadErr.Error()
+	} else if uploadErr != nil {
+		fmt.Printf("[%s] %v\n", target.Name, uploadErr)
+		dataFile.Status = models.RecordFailed
+		dataFile.Error = uploadErr.Error()
+	}
+
+	if err := w.storeRecord(entry, target, &dataFile); err != nil {
+		return err
+	}
+
+	if uploadErr != nil {
+		return uploadErr
+	}
+
+	fmt.Println("Store Record Success ID File: ", dataFile.ItemID)
+	return nil
+}
+
+// putObject upload the file to its folder on the target, the folder of the group for the near duplicate on the
+// group policy. the folder is returned even when the upload failed
+func (w *Watcher) putObject(entry *Entry, target *Target, rel, filepath, mimeType string, sim *similarity) (string, *storage.Object, error) {
+	folderId, err := w.folderFor(entry, target, rel)
+	if err == nil && sim.match != nil && w.similar == SimilarGroup {
+		var groupId string
+		if groupId, err = w.similarFolder(target, sim); groupId != "" {
+			folderId = groupId
+		}
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("Error Check Exist or Create Folder: %w", err)
+	}
+
+	obj, err := target.Storage.Put(w.ctx, folderId, path.Base(rel), filepath, mimeType)
+	if err != nil {
+		return folderId, nil, fmt.Errorf("Error Upload File: %w", err)
+	}
+
+	return folderId, obj, nil
+}
+
+// storeRecord store the result of the upload. a previous failed, rejected, duplicate or similar record of the same file is
+// replaced, so the file has one record per target
+func (w *Watcher) storeRecord(entry *Entry, target *Target, dataFile *models.Records) error {
+	tx, err := w.db.BeginTx(context.WithoutCancel(w.ctx), nil)
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := w.records.FindByPath(tx, entry.Path, dataFile.Path, target.Name)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("Error Find By Path: %v", err)
+	}
+
+	if previous != nil && previous.Target == target.Name && (previous.Status == models.RecordFailed || previous.Status == models.RecordRejected || previous.Status == models.RecordDuplicate || previous.Status == models.RecordSimilar) {
+		dataFile.ID = previous.ID
+		if previous.Status == models.RecordFailed || previous.Status == models.RecordRejected {
+			dataFile.Attempts = previous.Attempts + 1
+		}
+		err = w.records.Update(tx, dataFile)
+	} else {
+		err = w.records.Create(tx, dataFile)
+	}
+	if err != nil {
+		return fmt.Errorf("Error Store Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return nil
+}
+
+// updateTo replace the content of the uploaded file of the record with the local file (a new revision on drive).
+// the record is only changed on success, so a failed update is retried on the same remote file. the file gone
+// from the storage is uploaded again as a new file
+func (w *Watcher) updateTo(entry *Entry, target *Target, record *models.Records, filepath, mimeType string, sum *checksum.Sum, sim *similarity) error {
+	var obj *storage.Object
+	var err error
+
+	if updater, ok := target.Storage.(storage.Updater); ok {
+		obj, err = updater.Update(w.ctx, record.ItemID, filepath, mimeType)
+	} else if _, err = target.Storage.Stat(w.ctx, record.ItemID); err == nil {
+		// path based backend replace the file on Put with the same folder and name
+		obj, err = target.Storage.Put(w.ctx, record.FolderID, record.Name, filepath, mimeType)
+	}
+
+	if errors.Is(err, storage.ErrNotFound) {
+		fmt.Printf("[%s] '%s' is gone from the storage, upload it again\n", target.Name, record.Path)
+		if err := w.forgetRecord(record.ID); err != nil {
+			return err
+		}
+		return w.uploadTo(entry, target, record.Path, filepath, mimeType, false)
+	} else if err == nil && obj.MD5 != "" && obj.MD5 != sum.MD5 {
+		// the previous content is still a revision on drive, the retry update it again
+		err = fmt.Errorf("checksum mismatch, local md5 %s, uploaded %s", sum.MD5, obj.MD5)
+	}
+	if err != nil {
+		err = fmt.Errorf("Error Update File: %w", err)
+		if w.ctx.Err() == nil {
+			fmt.Printf("[%s] %v\n", target.Name, err)
+		}
+		return err
+	}
+
+	record.ItemID = obj.ID
+	record.MimeType = mimeType
+	record.Date = time.Now().String()
+	record.Size = sum.Size
+	record.MD5 = sum.MD5
+	record.SHA256 = sum.SHA256
+	record.PHash = sim.phash
+	record.ModTime = sim.modTime
+
+	tx, err := w.db.BeginTx(context.WithoutCancel(w.ctx), nil)
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := w.records.Update(tx, record); err != nil {
+		return fmt.Errorf("Error Store Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	fmt.Printf("Update File Success on %s ID: %s\n", target.Name, obj.ID)
+	return nil
+}
+
+// findRecord return the record of the file on the target, nil when there is none
+func (w *Watcher) findRecord(entry *Entry, target *Target, rel string) (*models.Records, error) {
+	tx, err := w.db.BeginTx(w.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	record, err := w.records.FindByPath(tx, entry.Path, rel, target.Name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Find By Path: %v", err)
+	}
+
+	return record, nil
+}
+
+// updateRecord store the changed record after a storage call, even when stopping meanwhile
+func (w *Watcher) updateRecord(record *models.Records) error {
+	tx, err := w.db.BeginTx(context.WithoutCancel(w.ctx), nil)
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := w.records.Update(tx, record); err != nil {
+		return fmt.Errorf("Error Store Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	return nil
+}
+
+// targetFor return the entry of the watch path and its target name
+func (w *Watcher) targetFor(watch, name string) (*Entry, *Target) {
+	for i := range w.entries {
+		if w.entries[i].Path != watch {
+			continue
+		}
+		for j := range w.entries[i].Targets {
+			if w.entries[i].Targets[j].Name == name {
+				return &w.entries[i], &w.entries[i].Targets[j]
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// removeFrom delete the file from one target, records without target are from the time the watch had one target
+// so only the first target own them. the error is returned when the file is still on the storage
+func (w *Watcher) removeFrom(entry *Entry, target *Target, first bool, rel string) error {
+	// first get file id from db based on the relative path
+	itemData, err := w.findRecord(entry, target, rel)
+	if err != nil {
+		return err
+	} else if itemData == nil || (itemData.Target == "" && !first) {
+		fmt.Printf("Data not found on DB for %s\n", target.Name)
+		return nil
+	}
+
+	// if uploaded, delete file from the storage, already gone there is fine. the uploaded file used by duplicates
+	// is kept for them. the storage is called before the transaction, so the database is not held meanwhile
+	var heir *models.Records
+	if itemData.Status == models.RecordUploaded {
+		if heir, err = w.handOver(target, itemData); err != nil {
+			return err
+		} else if heir == nil {
+			if err := target.Storage.Delete(w.ctx, itemData.ItemID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				fmt.Printf("[%s] Error Delete File: %v\n", target.Name, err)
+				return fmt.Errorf("Error Delete File: %w", err)
+			}
+
+			fmt.Printf("Delete File from %s Success ID: %s\n", target.Name, itemData.ItemID)
+		}
+	} else if itemData.Status == models.RecordDuplicate {
+		if err := w.dropShortcut(target, itemData); err != nil {
+			return err
+		}
+	}
+
+	// success delete from storage, continue delete data from db, even when stopping meanwhile
+	tx, err := w.db.BeginTx(context.WithoutCancel(w.ctx), nil)
+	if err != nil {
+		return fmt.Errorf("Error Begin Transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if heir != nil {
+		if err := w.storeHandOver(tx, target, itemData, heir); err != nil {
+			return err
+		}
+	}
+
+	if err := w.records.Delete(tx, itemData.ID); err != nil {
+		return fmt.Errorf("Error Delete Record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error Commit: %v", err)
+	}
+
+	fmt.Println("Delete Success from DB, ID File: ", itemData.ItemID)
+	return nil
+}
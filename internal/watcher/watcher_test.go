@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/internal/database"
+	"github.com/momokii/ss-watcher/internal/models"
+	"github.com/momokii/ss-watcher/pkg/localfs"
+)
+
+// waitFor check cond until it is true, the watcher loop run on its own goroutine
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// newLocalWatcher return the watcher of a temp folder with a local target on another temp folder
+func newLocalWatcher(t *testing.T, opts Options) (*Watcher, string) {
+	t.Helper()
+
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	nas := t.TempDir()
+	store, err := localfs.NewLocalFS(localfs.Config{Root: nas})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := Entry{
+		Path:        t.TempDir(),
+		Targets:     []Target{{Name: "nas", Storage: store, BaseFolderID: nas}},
+		DailyPrefix: "SS_",
+	}
+
+	return New(db, []Entry{entry}, opts), nas
+}
+
+func TestRunLocalTarget(t *testing.T) {
+	w, nas := newLocalWatcher(t, Options{DebounceWindow: 100 * time.Millisecond})
+	db, dir := w.db, w.entries[0].Path
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() error: %v", err)
+		}
+	})
+
+	record := func() *models.Records {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		r, err := w.records.FindByPath(tx, dir, "a.png", "nas")
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// the watch is added by Run, a write before it is not seen so the file is written again until it is uploaded
+	var written time.Time
+	waitFor(t, "the record of the upload", func() bool {
+		if r := record(); r != nil && r.Status == models.RecordUploaded {
+			return true
+		}
+		if time.Since(written) > time.Second {
+			if err := os.WriteFile(filepath.Join(dir, "a.png"), []byte("screenshot"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			written = time.Now()
+		}
+		return false
+	})
+
+	uploaded := record()
+	daily := filepath.Join(nas, "SS_"+time.Now().Format("2006-01-02"))
+	if uploaded.ItemID != filepath.Join(daily, "a.png") || uploaded.FolderID != daily {
+		t.Fatalf("record = %+v, want the file on the daily folder %s", uploaded, daily)
+	}
+	if data, err := os.ReadFile(uploaded.ItemID); err != nil || string(data) != "screenshot" {
+		t.Fatalf("uploaded content = %q, %v", data, err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the delete wait the move hold before it run
+	waitFor(t, "the remote delete", func() bool {
+		_, err := os.Stat(uploaded.ItemID)
+		return errors.Is(err, os.ErrNotExist) && record() == nil
+	})
+}
@@ -0,0 +1,40 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// Sum is the size and hex hashes of a file content
+type Sum struct {
+	Size int64
+	// md5 is compared with the checksum of the storage (drive md5Checksum, s3 etag), sha256 find the duplicates
+	MD5    string
+	SHA256 string
+}
+
+// File hash the content of the file with one read
+func File(path string) (*Sum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashMD5 := md5.New()
+	hashSHA256 := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(hashMD5, hashSHA256), f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sum{
+		Size:   size,
+		MD5:    hex.EncodeToString(hashMD5.Sum(nil)),
+		SHA256: hex.EncodeToString(hashSHA256.Sum(nil)),
+	}, nil
+}
@@ -3,46 +3,137 @@ package gdrive
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"time"
 
-	"github.com/momokii/ss-watcher/pkg/utils"
+	"github.com/momokii/ss-watcher/pkg/storage"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
+const folderMimeType = "application/vnd.google-apps.folder"
+
+const shortcutMimeType = "application/vnd.google-apps.shortcut"
+
+// GDrive is the google drive backend, it implement storage.Storage plus the drive specific helpers
 type GDrive interface {
+	storage.Storage
+
 	GetService() *drive.Service
 	CheckFolderExist(folderName string, parentId string) (string, error)
 	CreateFolder(folderName string, parentId string) (string, error)
-	CheckExistOrCreateFolderSSDaily(parentFolderCheckId string) (string, error)
+	CheckExistOrCreateFolderSSDaily(parentFolderCheckId, prefix string) (string, error)
+	CheckExistOrCreateFolderPath(parentFolderId, folderPath string) (string, error)
 	UploadFileDrive(filename, filepath, mimeType, parentFolderId string) (*drive.File, error)
 	DeleteFileDrive(id string) error
 	NewUserPermission(base_gdrive_folder_id, user_email string) (string, error)
 	DeleteUserPermission(permission_id string) error
 }
 
+type Config struct {
+	// name of the target, keep the resumable upload sessions of the targets apart
+	Name string
+	// path to the service account JSON file, used when OAuthClientPath is empty
+	ServiceAccountPath string
+	// path to the oauth client JSON ("Desktop app"), the files are uploaded to the drive of the logged in user
+	OAuthClientPath string
+	// token file created by Login
+	TokenPath string
+	// prefix of the daily folder name, the folder is named <prefix><YYYY-MM-DD>_<random>
+	DailyFolderPrefix string
+	// file bigger than this is uploaded in chunks with resumable upload, default DefaultChunkSize.
+	// rounded down to multiple of ChunkAlign
+	ChunkSize int64
+	// where the resumable upload sessions are kept, nil keep them only for the current upload
+	Sessions storage.UploadSessionStore
+	// called after every chunk of resumable upload, nil print the progress
+	Progress func(filename string, sent, total int64)
+	// retry of the drive calls on rate limit, server and network error, zero value use DefaultRetryPolicy
+	Retry RetryPolicy
+	// keep every revision of the updated files forever, drive remove the old revisions after 30 days without it
+	KeepRevisions bool
+}
+
 type gdrive struct {
-	Service *drive.Service
+	Service     *drive.Service
+	name        string
+	dailyPrefix string
+
+	// authorized client of the service, used for the resumable upload requests
+	client        *http.Client
+	chunkSize     int64
+	sessions      storage.UploadSessionStore
+	onProgress    func(filename string, sent, total int64)
+	retry         RetryPolicy
+	keepRevisions bool
 }
 
-func NewGDrive(service_account_path string) GDrive {
+func NewGDrive(cfg Config) (GDrive, error) {
 	ctx := context.Background()
 
-	// Replace with the path to your service account JSON file.
-	serviceAccountFile := service_account_path
+	if cfg.DailyFolderPrefix == "" {
+		cfg.DailyFolderPrefix = "SS_"
+	}
 
-	// Initialize the Drive service using the service account file.
-	srv, err := drive.NewService(ctx, option.WithCredentialsFile(serviceAccountFile), option.WithScopes(drive.DriveScope))
-	if err != nil {
-		fmt.Println("Error creating Drive service: ", err)
+	cfg.ChunkSize = cfg.ChunkSize / ChunkAlign * ChunkAlign
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DefaultChunkSize
+	}
+
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if cfg.Retry.BaseDelay <= 0 {
+		cfg.Retry.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if cfg.Retry.MaxDelay < cfg.Retry.BaseDelay {
+		cfg.Retry.MaxDelay = max(DefaultRetryPolicy.MaxDelay, cfg.Retry.BaseDelay)
+	}
+
+	var opts []option.ClientOption
+	if cfg.OAuthClientPath != "" {
+		// user account, token from 'ss-watcher auth login'
+		ts, err := oauthTokenSource(ctx, cfg.OAuthClientPath, cfg.TokenPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithTokenSource(ts))
 	} else {
-		fmt.Println("Drive service connected successfully")
+		if cfg.ServiceAccountPath == "" {
+			return nil, fmt.Errorf("Service account JSON path is empty")
+		}
+		opts = append(opts, option.WithCredentialsFile(cfg.ServiceAccountPath), option.WithScopes(drive.DriveScope))
 	}
 
-	return &gdrive{
-		Service: srv,
+	// Initialize the Drive service using the service account file or the user token.
+	// the client is created here so the resumable upload can use it too
+	client, _, err := htransport.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Drive client: %v", err)
 	}
+
+	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Drive service: %v", err)
+	}
+
+	fmt.Println("Drive service connected successfully")
+
+	return &gdrive{
+		Service:       srv,
+		name:          cfg.Name,
+		dailyPrefix:   cfg.DailyFolderPrefix,
+		client:        client,
+		chunkSize:     cfg.ChunkSize,
+		sessions:      cfg.Sessions,
+		onProgress:    cfg.Progress,
+		retry:         cfg.Retry,
+		keepRevisions: cfg.KeepRevisions,
+	}, nil
 }
 
 func (d *gdrive) GetService() *drive.Service {
@@ -51,34 +142,79 @@ func (d *gdrive) GetService() *drive.Service {
 
 func (d *gdrive) CheckFolderExist(folderName string, parentId string) (string, error) {
 	// gdrive query to check folder
+	query := fmt.Sprintf("name contains '%s' and mimeType='%s'", escapeQuery(folderName), folderMimeType)
+
+	return d.findFolder(context.Background(), query, parentId)
+}
+
+func (d *gdrive) CreateFolder(folderName string, parentId string) (string, error) {
+	return d.createFolder(context.Background(), folderName, parentId)
+}
+
+// CheckExistOrCreateFolderSSDaily return the id of today folder inside parentFolderCheckId, empty prefix use the configured one
+func (d *gdrive) CheckExistOrCreateFolderSSDaily(parentFolderCheckId, prefix string) (string, error) {
+	return d.EnsureDailyFolder(context.Background(), parentFolderCheckId, prefix, time.Now())
+}
+
+// CheckExistOrCreateFolderPath make sure every folder on the slash separated folderPath exist inside parentFolderId
+// and return the id of the last one. folder name is matched exactly, not with "contains" like CheckFolderExist
+func (d *gdrive) CheckExistOrCreateFolderPath(parentFolderId, folderPath string) (string, error) {
+	return storage.EnsureFolderPath(context.Background(), d, parentFolderId, folderPath)
+}
+
+func (d *gdrive) UploadFileDrive(filename, filepath, mimeType, parentFolderId string) (*drive.File, error) {
+	return d.upload(context.Background(), filename, filepath, mimeType, parentFolderId)
+}
+
+func (d *gdrive) DeleteFileDrive(id string) error {
+	return d.Delete(context.Background(), id)
+}
+
+func (d *gdrive) NewUserPermission(base_gdrive_folder_id, user_email string) (string, error) {
+	return d.createPermission(context.Background(), base_gdrive_folder_id, user_email)
+}
+
+func (d *gdrive) DeleteUserPermission(permission_id string) error {
+	// delete permission
+	err := d.call(context.Background(), "delete permission", func() error {
+		return d.Service.Permissions.Delete(permission_id, permission_id).Do()
+	})
+	if err != nil {
+		return fmt.Errorf("Error Delete Permission: %v", err)
+	}
 
-	query := fmt.Sprintf("name contains '%s' and mimeType='application/vnd.google-apps.folder'", folderName)
+	return nil
+}
 
+// findFolder run the folder query (parent and trashed filter added here) and return the first id, empty if not found
+func (d *gdrive) findFolder(ctx context.Context, query string, parentId string) (string, error) {
 	if parentId != "" {
-		query += fmt.Sprintf(" and '%s' in parents", parentId)
+		query += fmt.Sprintf(" and '%s' in parents", escapeQuery(parentId))
 	}
 
 	query += " and trashed=false"
 
-	fileList, err := d.Service.Files.List().Q(query).Fields("files(id, name)").Do()
+	var fileList *drive.FileList
+	err := d.call(ctx, "list folder", func() (err error) {
+		fileList, err = d.Service.Files.List().Q(query).Fields("files(id, name)").Context(ctx).Do()
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("Error checking folder: %v", err)
+		return "", fmt.Errorf("Error checking folder: %w", err)
 	}
 
 	if len(fileList.Files) > 0 {
-		fmt.Printf("Folder %s already exists with id: %s \n", folderName, fileList.Files[0].Id)
 		return fileList.Files[0].Id, nil
 	}
 
-	fmt.Println("Folder does not exist, creating folder...")
 	return "", nil
 }
 
-func (d *gdrive) CreateFolder(folderName string, parentId string) (string, error) {
+func (d *gdrive) createFolder(ctx context.Context, folderName string, parentId string) (string, error) {
 	// define folder
 	folder := &drive.File{
 		Name:     folderName,
-		MimeType: "application/vnd.google-apps.folder",
+		MimeType: folderMimeType,
 	}
 
 	// if parent provided, set it
@@ -90,47 +226,36 @@ func (d *gdrive) CreateFolder(folderName string, parentId string) (string, error
 	}
 
 	// create folder
-	createdFolder, err := d.Service.Files.Create(folder).Fields("id", "name").Do()
+	var createdFolder *drive.File
+	err := d.call(ctx, "create folder", func() (err error) {
+		createdFolder, err = d.Service.Files.Create(folder).Fields("id", "name").Context(ctx).Do()
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("Error creating folder: %v", err)
+		return "", fmt.Errorf("Error creating folder: %w", err)
 	}
 
 	fmt.Printf("Folder %s created with id: %s \n", folderName, createdFolder.Id)
 	return createdFolder.Id, nil
 }
 
-func (d *gdrive) CheckExistOrCreateFolderSSDaily(parentFolderCheckId string) (string, error) {
-	nameFolder := "SS_" // prefix folder name
-	dateFolder := time.Now().Format("2006-01-02")
-	randomCode := utils.RandomString(5)
-	checkFolderName := nameFolder + dateFolder
-	newFolderName := checkFolderName + "_" + randomCode
+func (d *gdrive) upload(ctx context.Context, filename, filepath, mimeType, parentFolderId string) (*drive.File, error) {
 
-	folderId, err := d.CheckFolderExist(checkFolderName, parentFolderCheckId) // id "test" folder
+	file, err := os.Open(filepath)
 	if err != nil {
-		fmt.Println("Error Check Folder Exist: ", err)
-		return "", err
+		return nil, fmt.Errorf("Error Open File: %v", err)
 	}
+	defer file.Close()
 
-	if folderId == "" {
-		fmt.Println("Creating Daily folder:")
-		folderId, err = d.CreateFolder(newFolderName, parentFolderCheckId)
-		if err != nil {
-			fmt.Println("Error Create Folder: ", err)
-			return "", err
-		}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Error Stat File: %v", err)
 	}
 
-	return folderId, nil
-}
-
-func (d *gdrive) UploadFileDrive(filename, filepath, mimeType, parentFolderId string) (*drive.File, error) {
-
-	file, err := os.Open(filepath)
-	if err != nil {
-		return nil, fmt.Errorf("Error Open File: %v", err)
+	// big file (screen recording) is sent in chunks, so a network error only resend the last chunk
+	if info.Size() > d.chunkSize {
+		return d.resumableUpload(ctx, file, info, "", filename, mimeType, parentFolderId)
 	}
-	defer file.Close()
 
 	fileMetadata := &drive.File{
 		Name:     filename,
@@ -141,62 +266,79 @@ func (d *gdrive) UploadFileDrive(filename, filepath, mimeType, parentFolderId st
 		fileMetadata.Parents = []string{parentFolderId}
 	}
 
-	fileUpload, err := d.Service.Files.Create(fileMetadata).Media(file).Do()
+	// the whole file is sent again on retry, ChunkSize(0) send it in one request so it is read from the start
+	var fileUpload *drive.File
+	err = d.call(ctx, "upload", func() (err error) {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		fileUpload, err = d.Service.Files.Create(fileMetadata).Media(file, googleapi.ChunkSize(0)).Fields(fileFields).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Error Upload File: %v", err)
+		return nil, fmt.Errorf("Error Upload File: %w", err)
 	}
 
 	return fileUpload, nil
 }
 
-func (d *gdrive) DeleteFileDrive(id string) error {
-	file, err := d.Service.Files.Get(id).Fields("mimeType").Do()
+// update send the new content of the file as a new revision, the name and parents stay the same
+func (d *gdrive) update(ctx context.Context, fileId, filepath, mimeType string) (*drive.File, error) {
+
+	file, err := os.Open(filepath)
 	if err != nil {
-		return fmt.Errorf("Error Get File: %v", err)
+		return nil, fmt.Errorf("Error Open File: %v", err)
 	}
+	defer file.Close()
 
-	// this function cannt delete folder
-	if file.MimeType == "application/vnd.google-apps.folder" {
-		return fmt.Errorf("Cannot delete folder")
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Error Stat File: %v", err)
 	}
 
-	// delete file
-	if err := d.Service.Files.Delete(id).Do(); err != nil {
-		return fmt.Errorf("Error Delete File: %v", err)
+	if info.Size() > d.chunkSize {
+		return d.resumableUpload(ctx, file, info, fileId, info.Name(), mimeType, "")
 	}
-	fmt.Println("File deleted with id: ", id)
 
-	return nil
+	var fileUpdate *drive.File
+	err = d.call(ctx, "update", func() (err error) {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		fileUpdate, err = d.Service.Files.Update(fileId, &drive.File{MimeType: mimeType}).
+			Media(file, googleapi.ChunkSize(0)).
+			KeepRevisionForever(d.keepRevisions).
+			Fields(fileFields).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Update File: %w", err)
+	}
+
+	return fileUpdate, nil
 }
 
-func (d *gdrive) NewUserPermission(base_gdrive_folder_id, user_email string) (string, error) {
+func (d *gdrive) createPermission(ctx context.Context, folderId, email string) (string, error) {
 	perm := &drive.Permission{
 		Type:         "user",
 		Role:         "writer",
-		EmailAddress: user_email,
+		EmailAddress: email,
 	}
 
 	// give permission to owner as writer so the service account still can access the folder
-	permission, err := d.Service.Permissions.Create(base_gdrive_folder_id, perm).Do()
+	// the folder is not deleted on error, the base folder can already contain uploaded files
+	// and sharing will be retried on the next run
+	var permission *drive.Permission
+	err := d.call(ctx, "create permission", func() (err error) {
+		permission, err = d.Service.Permissions.Create(folderId, perm).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
-		fmt.Println("Error Transfer Ownership: ", err)
-		// delete folder if error on giving ownership
-		if err := d.Service.Files.Delete(base_gdrive_folder_id).Do(); err != nil {
-			return "", fmt.Errorf("Error Canceling Create Base Folder: %v", err)
-		}
-
-		return "", fmt.Errorf("Cancelling Create Base Folder Success")
+		return "", fmt.Errorf("Error Create Permission: %w", err)
 	}
 
 	// return permission id
 	return permission.Id, nil
 }
-
-func (d *gdrive) DeleteUserPermission(permission_id string) error {
-	// delete permission
-	if err := d.Service.Permissions.Delete(permission_id, permission_id).Do(); err != nil {
-		return fmt.Errorf("Error Delete Permission: %v", err)
-	}
-
-	return nil
-}
@@ -0,0 +1,263 @@
+package gdrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// scope of the oauth login, ss-watcher only see the files and folders it created on the user drive
+var oauthScopes = []string{drive.DriveFileScope}
+
+const revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// ErrNotLoggedIn is returned when the oauth token file does not exist yet
+var ErrNotLoggedIn = errors.New("not logged in, run 'ss-watcher auth login'")
+
+func oauthConfig(clientSecretPath string) (*oauth2.Config, error) {
+	b, err := os.ReadFile(clientSecretPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error Read OAuth Client Secret: %v", err)
+	}
+
+	// "Desktop app" client from the google cloud console
+	conf, err := google.ConfigFromJSON(b, oauthScopes...)
+	if err != nil {
+		return nil, fmt.Errorf("Error Parse OAuth Client Secret: %v", err)
+	}
+
+	return conf, nil
+}
+
+// Login run the installed app flow: the consent page is opened on the browser and google redirect back to
+// a one shot server on the loopback address. the token (with the refresh token) is saved on tokenPath
+func Login(ctx context.Context, clientSecretPath, tokenPath string, openURL func(url string) error) error {
+	conf, err := oauthConfig(clientSecretPath)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("Error Listen Loopback: %v", err)
+	}
+	defer ln.Close()
+
+	conf.RedirectURL = fmt.Sprintf("http://%s/callback", ln.Addr().String())
+
+	// state protect the callback from other page, verifier (PKCE) protect the code from other local app
+	state := oauth2.GenerateVerifier()
+	verifier := oauth2.GenerateVerifier()
+
+	// offline + consent so google always return a refresh token, also on the second login
+	authURL := conf.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier))
+
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	var once sync.Once
+	finish := func(r result) { once.Do(func() { done <- r }) }
+
+	srv := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/callback" {
+				http.NotFound(w, r)
+				return
+			}
+
+			q := r.URL.Query()
+			if q.Get("state") != state {
+				http.Error(w, "Invalid state, start the login again.", http.StatusBadRequest)
+				return
+			}
+
+			if e := q.Get("error"); e != "" {
+				http.Error(w, "Login canceled: "+e, http.StatusBadRequest)
+				finish(result{err: fmt.Errorf("login canceled: %s", e)})
+				return
+			}
+
+			fmt.Fprintln(w, "ss-watcher is logged in, you can close this tab.")
+			finish(result{code: q.Get("code")})
+		}),
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	fmt.Println("Open this url on the browser to log in:")
+	fmt.Println()
+	fmt.Println(authURL)
+	fmt.Println()
+	if openURL != nil {
+		if err := openURL(authURL); err != nil {
+			fmt.Println("Cannot open the browser, open the url manually: ", err)
+		}
+	}
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("Login timeout: %v", ctx.Err())
+	}
+	if res.err != nil {
+		return res.err
+	}
+
+	tok, err := conf.Exchange(ctx, res.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return fmt.Errorf("Error Exchange Code: %v", err)
+	}
+
+	if tok.RefreshToken == "" {
+		fmt.Println("Warning: no refresh token returned, the login has to be done again when the token expire")
+	}
+
+	if err := SaveToken(tokenPath, tok); err != nil {
+		return err
+	}
+
+	fmt.Println("Token saved on: ", tokenPath)
+
+	return nil
+}
+
+// Logout revoke the token on google (best effort) and remove the token file
+func Logout(ctx context.Context, tokenPath string) error {
+	tok, err := LoadToken(tokenPath)
+	if err != nil {
+		return err
+	}
+
+	revoke := tok.RefreshToken
+	if revoke == "" {
+		revoke = tok.AccessToken
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeURL, strings.NewReader(url.Values{"token": {revoke}}.Encode()))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if resp, err := http.DefaultClient.Do(req); err != nil {
+			fmt.Println("Error Revoke Token: ", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				fmt.Println("Error Revoke Token: ", resp.Status)
+			}
+		}
+	}
+
+	if err := os.Remove(tokenPath); err != nil {
+		return fmt.Errorf("Error Remove Token: %v", err)
+	}
+
+	return nil
+}
+
+func LoadToken(path string) (*oauth2.Token, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotLoggedIn
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Read Token: %v", err)
+	}
+
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(b, tok); err != nil {
+		return nil, fmt.Errorf("Error Parse Token: %v", err)
+	}
+
+	return tok, nil
+}
+
+// SaveToken write the token only readable by the user, with temp file and rename so a crash never leave half token
+func SaveToken(path string, tok *oauth2.Token) error {
+	b, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error Encode Token: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("Error Create Token Folder: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("Error Write Token: %v", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Error Write Token: %v", err)
+	}
+
+	return nil
+}
+
+// oauthTokenSource return token source that refresh the access token and save the refreshed one,
+// so the next start does not need a refresh right away
+func oauthTokenSource(ctx context.Context, clientSecretPath, tokenPath string) (oauth2.TokenSource, error) {
+	conf, err := oauthConfig(clientSecretPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := LoadToken(tokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.RefreshToken == "" && !tok.Valid() {
+		return nil, fmt.Errorf("token expired and has no refresh token, run 'ss-watcher auth login' again")
+	}
+
+	return &savingTokenSource{
+		base: conf.TokenSource(ctx, tok),
+		path: tokenPath,
+		last: tok.AccessToken,
+	}, nil
+}
+
+type savingTokenSource struct {
+	base oauth2.TokenSource
+	path string
+
+	mu   sync.Mutex
+	last string
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tok.AccessToken != s.last {
+		s.last = tok.AccessToken
+		if err := SaveToken(s.path, tok); err != nil {
+			// the token in memory still work, only the next start need to refresh again
+			fmt.Println("Error Save Refreshed Token: ", err)
+		}
+	}
+
+	return tok, nil
+}
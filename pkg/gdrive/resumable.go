@@ -0,0 +1,305 @@
+package gdrive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	"github.com/momokii/ss-watcher/pkg/utils"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultChunkSize is the size of each request of the resumable upload, file up to this size is sent in one request
+const DefaultChunkSize = 8 << 20
+
+// drive require the chunk size to be multiple of 256 KB
+const ChunkAlign = 256 << 10
+
+// drive keep the session for one week, older one is not tried
+const sessionMaxAge = 6 * 24 * time.Hour
+
+var resumableURL = "https://www.googleapis.com/upload/drive/v3/files?uploadType=resumable&fields=" + url.QueryEscape(fileFields)
+
+// resumable upload of a new revision, file id and keepRevisionForever
+var resumableUpdateURL = "https://www.googleapis.com/upload/drive/v3/files/%s?uploadType=resumable&keepRevisionForever=%t&fields=" + url.QueryEscape(fileFields)
+
+// errSessionExpired is returned when drive does not know the session anymore, the upload start from zero
+var errSessionExpired = fmt.Errorf("upload session expired")
+
+// resumableUpload send the file in chunks with the drive resumable protocol. the session uri is stored on the
+// session store, so a failed upload (network error, restart) continue from the last chunk received by drive.
+// the session is found by the local file and keep its folder, so an upload started before midnight continue in the
+// daily folder of the day before. with fileId the file is sent as a new revision of it, parentFolderId is not used
+func (d *gdrive) resumableUpload(ctx context.Context, file *os.File, info os.FileInfo, fileId, filename, mimeType, parentFolderId string) (*drive.File, error) {
+	key := fmt.Sprintf("gdrive|%s|%s|%d|%d", d.name, file.Name(), info.Size(), info.ModTime().UnixNano())
+	if fileId != "" {
+		key = fmt.Sprintf("gdrive|%s|update|%s|%s|%d|%d", d.name, fileId, file.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	size := info.Size()
+
+	uri, offset := "", int64(0)
+	if session := d.loadSession(key, info); session != nil {
+		var done *drive.File
+		err := d.call(ctx, "query upload", func() (err error) {
+			offset, done, err = d.queryOffset(ctx, session.URI, size)
+			return err
+		})
+		if err == errSessionExpired {
+			d.deleteSession(key)
+		} else if err != nil {
+			return nil, err
+		} else if done != nil {
+			// finished before the session could be deleted
+			d.deleteSession(key)
+			return done, nil
+		} else {
+			uri = session.URI
+			fmt.Printf("Resume upload %s from %s\n", filename, utils.FormatSize(offset))
+			if session.Folder != parentFolderId {
+				fmt.Printf("Upload %s continue in the folder of the session %s\n", filename, session.Folder)
+			}
+		}
+	}
+
+	if uri == "" {
+		err := d.call(ctx, "start upload", func() (err error) {
+			uri, err = d.startSession(ctx, fileId, filename, mimeType, parentFolderId, size)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if d.sessions != nil {
+			if err := d.sessions.SaveSession(&storage.UploadSession{
+				Key:     key,
+				URI:     uri,
+				Size:    size,
+				ModTime: info.ModTime(),
+				Created: time.Now(),
+				Folder:  parentFolderId,
+			}); err != nil {
+				fmt.Println("Error Save Upload Session: ", err)
+			}
+		}
+	}
+
+	// after a failed chunk drive may have received part of it, so ask the offset again before the retry
+	resync := false
+
+	for {
+		var result *drive.File
+		var next int64
+
+		err := d.call(ctx, "upload chunk", func() (err error) {
+			if resync {
+				if offset, result, err = d.queryOffset(ctx, uri, size); err != nil || result != nil {
+					return err
+				}
+				resync = false
+			}
+
+			n := d.chunkSize
+			if size-offset < n {
+				n = size - offset
+			}
+
+			result, next, err = d.sendChunk(ctx, uri, io.NewSectionReader(file, offset, n), offset, n, size)
+			if err != nil {
+				resync = true
+			}
+			return err
+		})
+		if err == errSessionExpired {
+			// the next retry start a new session
+			d.deleteSession(key)
+			return nil, fmt.Errorf("Error Upload File: %v", err)
+		} else if err != nil {
+			return nil, err
+		}
+
+		if result != nil {
+			d.deleteSession(key)
+			d.progress(filename, size, size)
+			return result, nil
+		}
+
+		offset = next
+		d.progress(filename, offset, size)
+	}
+}
+
+func (d *gdrive) loadSession(key string, info os.FileInfo) *storage.UploadSession {
+	if d.sessions == nil {
+		return nil
+	}
+
+	session, err := d.sessions.GetSession(key)
+	if err != nil {
+		fmt.Println("Error Get Upload Session: ", err)
+		return nil
+	}
+	if session == nil {
+		return nil
+	}
+
+	// file changed or session too old, drive would reject it or upload the wrong content
+	if session.Size != info.Size() || !session.ModTime.Equal(info.ModTime()) || time.Since(session.Created) > sessionMaxAge {
+		d.deleteSession(key)
+		return nil
+	}
+
+	return session
+}
+
+func (d *gdrive) deleteSession(key string) {
+	if d.sessions == nil {
+		return
+	}
+
+	if err := d.sessions.DeleteSession(key); err != nil {
+		fmt.Println("Error Delete Upload Session: ", err)
+	}
+}
+
+// startSession send the metadata and return the session uri, with fileId the session upload a new revision of it
+func (d *gdrive) startSession(ctx context.Context, fileId, filename, mimeType, parentFolderId string, size int64) (string, error) {
+	method, endpoint := http.MethodPost, resumableURL
+	meta := &drive.File{
+		Name:     filename,
+		MimeType: mimeType,
+	}
+	if parentFolderId != "" {
+		meta.Parents = []string{parentFolderId}
+	}
+
+	if fileId != "" {
+		// only the content change, the name and parents stay
+		method, endpoint = http.MethodPatch, fmt.Sprintf(resumableUpdateURL, url.PathEscape(fileId), d.keepRevisions)
+		meta = &drive.File{MimeType: mimeType}
+	}
+
+	body, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", mimeType)
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Error Start Upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return "", fmt.Errorf("Error Start Upload: %w", err)
+	}
+
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return "", fmt.Errorf("Error Start Upload: no session uri")
+	}
+
+	return uri, nil
+}
+
+// queryOffset ask drive how many bytes of the session it already has. the session that already received the whole
+// content give the uploaded file instead
+func (d *gdrive) queryOffset(ctx context.Context, uri string, size int64) (int64, *drive.File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Error Query Upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPermanentRedirect:
+		return nextOffset(resp), nil, nil
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		// finished before the session could be deleted, uploading it again would make a second file
+		file := &drive.File{}
+		if err := json.NewDecoder(resp.Body).Decode(file); err != nil {
+			return 0, nil, fmt.Errorf("Error Decode Upload Result: %v", err)
+		}
+		return size, file, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return 0, nil, errSessionExpired
+	default:
+		return 0, nil, fmt.Errorf("Error Query Upload: %w", googleapi.CheckResponse(resp))
+	}
+}
+
+// sendChunk upload n bytes from offset, return the file when drive has the whole content or the next offset
+func (d *gdrive) sendChunk(ctx context.Context, uri string, chunk io.Reader, offset, n, size int64) (*drive.File, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, chunk)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.ContentLength = n
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error Upload Chunk: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPermanentRedirect:
+		return nil, nextOffset(resp), nil
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		file := &drive.File{}
+		if err := json.NewDecoder(resp.Body).Decode(file); err != nil {
+			return nil, 0, fmt.Errorf("Error Decode Upload Result: %v", err)
+		}
+		return file, size, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, 0, errSessionExpired
+	default:
+		return nil, 0, fmt.Errorf("Error Upload Chunk: %w", googleapi.CheckResponse(resp))
+	}
+}
+
+// nextOffset read the "Range: bytes=0-N" header of the 308 response, no header mean nothing received yet
+func nextOffset(resp *http.Response) int64 {
+	r := resp.Header.Get("Range")
+	if i := strings.LastIndex(r, "-"); i >= 0 {
+		if end, err := strconv.ParseInt(r[i+1:], 10, 64); err == nil {
+			return end + 1
+		}
+	}
+	return 0
+}
+
+// progress print the upload progress of big file, small file is sent in one request so there is nothing to show
+func (d *gdrive) progress(filename string, sent, total int64) {
+	if d.onProgress != nil {
+		d.onProgress(filename, sent, total)
+		return
+	}
+
+	fmt.Printf("Upload %s: %d%% (%s / %s)\n", filename, sent*100/total, utils.FormatSize(sent), utils.FormatSize(total))
+}
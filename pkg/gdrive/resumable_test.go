@@ -0,0 +1,228 @@
+package gdrive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// newTestDrive return the backend talking to the drive stand-in, without wait between the retries
+func newTestDrive(t *testing.T, handler http.Handler, attempts int) (*gdrive, *httptest.Server) {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	service, err := drive.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &gdrive{
+		Service:    service,
+		name:       "drive",
+		client:     srv.Client(),
+		chunkSize:  DefaultChunkSize,
+		onProgress: func(string, int64, int64) {},
+		retry:      RetryPolicy{MaxAttempts: attempts, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	}, srv
+}
+
+// memSessions is the session store of the tests
+type memSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*storage.UploadSession
+}
+
+func (m *memSessions) GetSession(key string) (*storage.UploadSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[key], nil
+}
+
+func (m *memSessions) SaveSession(session *storage.UploadSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.Key] = session
+	return nil
+}
+
+func (m *memSessions) DeleteSession(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, key)
+	return nil
+}
+
+// resumableServer is the drive resumable upload stand-in. the response of the chunk that complete the upload is
+// lost (connection closed) when dropLast is set, like a network failure right after drive got the whole file
+type resumableServer struct {
+	mu       sync.Mutex
+	url      string
+	starts   int
+	parents  map[string][]string
+	received map[string][]byte
+	dropLast bool
+}
+
+func (s *resumableServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload":
+		var meta drive.File
+		json.NewDecoder(r.Body).Decode(&meta)
+
+		s.starts++
+		id := fmt.Sprintf("session%d", s.starts)
+		s.parents[id] = meta.Parents
+		w.Header().Set("Location", s.url+"/session/"+id)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/session/"):
+		id := strings.TrimPrefix(r.URL.Path, "/session/")
+		data, _ := io.ReadAll(r.Body)
+		s.received[id] = append(s.received[id], data...)
+
+		var total int
+		fmt.Sscanf(r.Header.Get("Content-Range")[strings.LastIndex(r.Header.Get("Content-Range"), "/")+1:], "%d", &total)
+
+		if len(s.received[id]) < total {
+			if n := len(s.received[id]); n > 0 {
+				w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+			}
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+
+		if s.dropLast && len(data) > 0 {
+			s.dropLast = false
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+
+		json.NewEncoder(w).Encode(&drive.File{Id: "file-" + id, Name: "a.mp4", Parents: s.parents[id], Size: int64(total)})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestResumeFinishedSession(t *testing.T) {
+	server := &resumableServer{parents: make(map[string][]string), received: make(map[string][]byte), dropLast: true}
+	d, srv := newTestDrive(t, server, 1)
+	server.url = srv.URL
+	d.sessions = &memSessions{sessions: make(map[string]*storage.UploadSession)}
+
+	previous := resumableURL
+	resumableURL = srv.URL + "/upload"
+	t.Cleanup(func() { resumableURL = previous })
+
+	local := filepath.Join(t.TempDir(), "a.mp4")
+	if err := os.WriteFile(local, []byte("screen recording"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(folder string) (*drive.File, error) {
+		file, err := os.Open(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.resumableUpload(context.Background(), file, info, "", "a.mp4", "video/mp4", folder)
+	}
+
+	// drive got the whole file but the response is lost
+	if _, err := upload("SS_2024-03-09"); err == nil {
+		t.Fatal("first upload: want the network error")
+	}
+
+	// the retry after midnight has another daily folder, the session of the file is found anyway and drive answer
+	// that it is complete: the file is used instead of uploaded again
+	file, err := upload("SS_2024-03-10")
+	if err != nil {
+		t.Fatalf("retry error: %v", err)
+	}
+	if server.starts != 1 {
+		t.Fatalf("%d upload sessions started, want 1 (no second copy on drive)", server.starts)
+	}
+	if file.Id != "file-session1" || len(file.Parents) != 1 || file.Parents[0] != "SS_2024-03-09" {
+		t.Fatalf("retry = %+v, want the file of the first session", file)
+	}
+	if len(d.sessions.(*memSessions).sessions) != 0 {
+		t.Fatal("session kept after the upload finished")
+	}
+}
+
+func TestResumePartialSession(t *testing.T) {
+	server := &resumableServer{parents: make(map[string][]string), received: make(map[string][]byte)}
+	d, srv := newTestDrive(t, server, 1)
+	server.url = srv.URL
+	d.chunkSize = 4
+	sessions := &memSessions{sessions: make(map[string]*storage.UploadSession)}
+	d.sessions = sessions
+
+	previous := resumableURL
+	resumableURL = srv.URL + "/upload"
+	t.Cleanup(func() { resumableURL = previous })
+
+	local := filepath.Join(t.TempDir(), "a.mp4")
+	if err := os.WriteFile(local, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stopped after the first chunk, the session is stored with its folder
+	ctx, cancel := context.WithCancel(context.Background())
+	d.onProgress = func(string, int64, int64) { cancel() }
+	if _, err := d.resumableUpload(ctx, file, info, "", "a.mp4", "video/mp4", "SS_2024-03-09"); err == nil {
+		t.Fatal("canceled upload: want error")
+	}
+	if len(sessions.sessions) != 1 {
+		t.Fatalf("%d sessions stored, want 1", len(sessions.sessions))
+	}
+	for _, session := range sessions.sessions {
+		if session.Folder != "SS_2024-03-09" {
+			t.Fatalf("session folder = %q", session.Folder)
+		}
+	}
+
+	d.onProgress = func(string, int64, int64) {}
+	result, err := d.resumableUpload(context.Background(), file, info, "", "a.mp4", "video/mp4", "SS_2024-03-10")
+	if err != nil {
+		t.Fatalf("resume error: %v", err)
+	}
+	if server.starts != 1 || string(server.received["session1"]) != "0123456789" {
+		t.Fatalf("starts %d, received %q", server.starts, server.received["session1"])
+	}
+	if result.Parents[0] != "SS_2024-03-09" {
+		t.Fatalf("resumed file parents = %v, want the folder of the session", result.Parents)
+	}
+}
@@ -0,0 +1,172 @@
+package gdrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy of every drive call, zero value use the defaults
+type RetryPolicy struct {
+	// total tries of one call, 1 disable the retry
+	MaxAttempts int
+	// the wait before the n-th retry is random between 0 and min(MaxDelay, BaseDelay * 2^(n-1))
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    32 * time.Second,
+}
+
+// longer Retry-After is not waited inside the call, the error is returned and the upload retried later
+const maxRetryAfter = 5 * time.Minute
+
+type errorClass int
+
+const (
+	// unknown error, returned as is
+	classOther errorClass = iota
+	// rate limit, server error, network error
+	classRetryable
+	// will fail again, wrapped with storage.ErrPermanent
+	classPermanent
+	// 401, mostly the token expired between the refresh and the request, retried once
+	classUnauthorized
+	// missing file or folder, wrapped with storage.ErrNotFound so the caller can create it again
+	classNotFound
+)
+
+// reasons of 403 that are rate limit and not missing permission
+var rateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+}
+
+// classify the error of drive call and return the Retry-After of the response if any
+func classify(err error) (errorClass, time.Duration) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return classOther, 0
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		after := retryAfter(gerr.Header)
+
+		switch {
+		case gerr.Code == http.StatusTooManyRequests || gerr.Code == http.StatusRequestTimeout || gerr.Code >= 500:
+			return classRetryable, after
+		case gerr.Code == http.StatusUnauthorized:
+			return classUnauthorized, 0
+		case gerr.Code == http.StatusNotFound:
+			return classNotFound, 0
+		case gerr.Code == http.StatusForbidden:
+			for _, item := range gerr.Errors {
+				if rateLimitReasons[item.Reason] {
+					return classRetryable, after
+				}
+			}
+		}
+
+		if gerr.Code >= 400 {
+			return classPermanent, 0
+		}
+
+		return classOther, 0
+	}
+
+	// connection reset, timeout, dns failure, offline
+	var nerr net.Error
+	if errors.As(err, &nerr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return classRetryable, 0
+	}
+
+	return classOther, 0
+}
+
+// retryAfter parse the header in seconds or http date
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+
+	if sec, err := strconv.Atoi(v); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+// backoff return the jittered wait before the retry number attempt (1 based)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	max := p.BaseDelay << (attempt - 1)
+	if max <= 0 || max > p.MaxDelay {
+		max = p.MaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// call run fn until it succeed, fail with not retryable error or run out of attempts.
+// permanent error is wrapped with storage.ErrPermanent, 404 with storage.ErrNotFound and the retryable error
+// left after the attempts with storage.ErrUnavailable
+func (d *gdrive) call(ctx context.Context, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		class, after := classify(err)
+		switch class {
+		case classPermanent:
+			return fmt.Errorf("%w: %w", storage.ErrPermanent, err)
+		case classNotFound:
+			return fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+		case classUnauthorized:
+			// the transport get a new token on the next request, a second 401 is a revoked or bad credential
+			if attempt > 1 {
+				return err
+			}
+		case classOther:
+			return err
+		}
+		if attempt >= d.retry.MaxAttempts || after > maxRetryAfter {
+			if class == classRetryable {
+				return fmt.Errorf("%w: %w", storage.ErrUnavailable, err)
+			}
+			return err
+		}
+
+		wait := d.retry.backoff(attempt)
+		if after > wait {
+			wait = after
+		}
+
+		fmt.Printf("Drive %s failed (attempt %d/%d), retry in %s: %v\n", name, attempt, d.retry.MaxAttempts, wait.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
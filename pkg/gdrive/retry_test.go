@@ -0,0 +1,162 @@
+package gdrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	"google.golang.org/api/drive/v3"
+)
+
+// reply is one answer of the drive stand-in, code 0 close the connection without response
+type reply struct {
+	code       int
+	reason     string
+	retryAfter string
+}
+
+// scriptedDrive answer the files.get calls with the replies in order, the last one is repeated
+type scriptedDrive struct {
+	mu      sync.Mutex
+	replies []reply
+	calls   int
+}
+
+func (s *scriptedDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	rep := s.replies[min(s.calls, len(s.replies)-1)]
+	s.calls++
+	s.mu.Unlock()
+
+	switch {
+	case rep.code == 0:
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	case rep.code == http.StatusOK:
+		json.NewEncoder(w).Encode(&drive.File{Id: "file1", Name: "a.png", MimeType: "image/png", Size: 10})
+	default:
+		if rep.retryAfter != "" {
+			w.Header().Set("Retry-After", rep.retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rep.code)
+		fmt.Fprintf(w, `{"error":{"code":%d,"message":"scripted","errors":[{"reason":%q}]}}`, rep.code, rep.reason)
+	}
+}
+
+func (s *scriptedDrive) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func statWith(t *testing.T, ctx context.Context, attempts int, replies ...reply) (*storage.Object, *scriptedDrive, error) {
+	t.Helper()
+
+	server := &scriptedDrive{replies: replies}
+	d, _ := newTestDrive(t, server, attempts)
+	obj, err := d.Stat(ctx, "file1")
+
+	return obj, server, err
+}
+
+func TestRetryTooManyRequests(t *testing.T) {
+	start := time.Now()
+	obj, server, err := statWith(t, context.Background(), 5, reply{code: 429, retryAfter: "1"}, reply{code: 200})
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if obj.ID != "file1" || server.count() != 2 {
+		t.Fatalf("Stat() = %+v after %d calls", obj, server.count())
+	}
+	// the backoff of the test is 1ms, the wait come from the header
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %s, want the Retry-After of 1s", elapsed)
+	}
+}
+
+func TestRetryServerError(t *testing.T) {
+	_, server, err := statWith(t, context.Background(), 5, reply{code: 503}, reply{code: 500}, reply{code: 200})
+	if err != nil || server.count() != 3 {
+		t.Fatalf("Stat() error %v after %d calls, want success on the third", err, server.count())
+	}
+
+	// out of attempts, returned to retry later and not rejected
+	_, server, err = statWith(t, context.Background(), 3, reply{code: 502})
+	if err == nil || server.count() != 3 {
+		t.Fatalf("Stat() error %v after %d calls, want error after 3", err, server.count())
+	}
+	if errors.Is(err, storage.ErrPermanent) || !errors.Is(err, storage.ErrUnavailable) {
+		t.Fatalf("Stat() error = %v, want unavailable and not permanent", err)
+	}
+}
+
+func TestRetryForbidden(t *testing.T) {
+	_, server, err := statWith(t, context.Background(), 5, reply{code: 403, reason: "userRateLimitExceeded"}, reply{code: 200})
+	if err != nil || server.count() != 2 {
+		t.Fatalf("rate limit 403: error %v after %d calls, want retried", err, server.count())
+	}
+
+	_, server, err = statWith(t, context.Background(), 5, reply{code: 403, reason: "insufficientFilePermissions"}, reply{code: 200})
+	if !errors.Is(err, storage.ErrPermanent) || server.count() != 1 {
+		t.Fatalf("plain 403: error %v after %d calls, want permanent without retry", err, server.count())
+	}
+}
+
+func TestRetryUnauthorized(t *testing.T) {
+	_, server, err := statWith(t, context.Background(), 5, reply{code: 401, reason: "authError"}, reply{code: 200})
+	if err != nil || server.count() != 2 {
+		t.Fatalf("401 once: error %v after %d calls, want retried", err, server.count())
+	}
+
+	_, server, err = statWith(t, context.Background(), 5, reply{code: 401, reason: "authError"})
+	if err == nil || server.count() != 2 {
+		t.Fatalf("401 again: error %v after %d calls, want error after one retry", err, server.count())
+	}
+	if errors.Is(err, storage.ErrPermanent) {
+		t.Fatalf("401 error = %v, want not permanent", err)
+	}
+}
+
+func TestRetryNotFound(t *testing.T) {
+	_, server, err := statWith(t, context.Background(), 5, reply{code: 404, reason: "notFound"})
+	if !errors.Is(err, storage.ErrNotFound) || server.count() != 1 {
+		t.Fatalf("404: error %v after %d calls, want ErrNotFound without retry", err, server.count())
+	}
+	if errors.Is(err, storage.ErrPermanent) {
+		t.Fatalf("404 error = %v, want not permanent", err)
+	}
+}
+
+func TestRetryConnectionReset(t *testing.T) {
+	_, server, err := statWith(t, context.Background(), 5, reply{code: 0}, reply{code: 200})
+	if err != nil || server.count() != 2 {
+		t.Fatalf("Stat() error %v after %d calls, want retried after the reset", err, server.count())
+	}
+}
+
+func TestRetryContextCancel(t *testing.T) {
+	server := &scriptedDrive{replies: []reply{{code: 503}}}
+	d, _ := newTestDrive(t, server, 5)
+	d.retry.BaseDelay, d.retry.MaxDelay = time.Minute, time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := d.Stat(ctx, "file1"); err == nil {
+		t.Fatal("Stat() canceled: want error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Stat() returned after %s, want the wait stopped by the cancel", elapsed)
+	}
+	if server.count() > 2 {
+		t.Fatalf("%d calls after the cancel", server.count())
+	}
+}
@@ -0,0 +1,250 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	"github.com/momokii/ss-watcher/pkg/utils"
+	"google.golang.org/api/drive/v3"
+)
+
+// metadata requested for every file, mapped to storage.Object
+const fileFields = "id, name, parents, size, mimeType, md5Checksum, modifiedTime, trashed"
+
+func (d *gdrive) Kind() string {
+	return "gdrive"
+}
+
+// EnsureFolder find the folder with the exact name inside parentID (any parent when empty) or create it
+func (d *gdrive) EnsureFolder(ctx context.Context, parentID, name string) (string, error) {
+	query := fmt.Sprintf("name = '%s' and mimeType='%s'", escapeQuery(name), folderMimeType)
+
+	id, err := d.findFolder(ctx, query, parentID)
+	if err != nil {
+		return "", err
+	}
+
+	if id != "" {
+		return id, nil
+	}
+
+	return d.createFolder(ctx, name, parentID)
+}
+
+// EnsureDailyFolder keep the drive daily layout: the folder is found by "<prefix><date>" prefix
+// and created as "<prefix><date>_<random>", so folder from older version is still used
+func (d *gdrive) EnsureDailyFolder(ctx context.Context, baseID, prefix string, day time.Time) (string, error) {
+	if prefix == "" {
+		prefix = d.dailyPrefix
+	}
+
+	checkFolderName := storage.DailyFolderName(prefix, day)
+	newFolderName := checkFolderName + "_" + utils.RandomString(5)
+
+	query := fmt.Sprintf("name contains '%s' and mimeType='%s'", escapeQuery(checkFolderName), folderMimeType)
+
+	folderId, err := d.findFolder(ctx, query, baseID)
+	if err != nil {
+		return "", err
+	}
+
+	if folderId == "" {
+		fmt.Println("Creating Daily folder:")
+		return d.createFolder(ctx, newFolderName, baseID)
+	}
+
+	return folderId, nil
+}
+
+func (d *gdrive) Put(ctx context.Context, folderID, name, localPath, mimeType string) (*storage.Object, error) {
+	file, err := d.upload(ctx, name, localPath, mimeType, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return toObject(file), nil
+}
+
+// Update upload the new content as a new revision of the same file, so the link and the shares stay the same
+func (d *gdrive) Update(ctx context.Context, id, localPath, mimeType string) (*storage.Object, error) {
+	file, err := d.update(ctx, id, localPath, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return toObject(file), nil
+}
+
+func (d *gdrive) Delete(ctx context.Context, id string) error {
+	var file *drive.File
+	err := d.call(ctx, "get file", func() (err error) {
+		file, err = d.Service.Files.Get(id).Fields("mimeType").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error Get File: %w", err)
+	}
+
+	// this function cannt delete folder
+	if file.MimeType == folderMimeType {
+		return fmt.Errorf("Cannot delete folder")
+	}
+
+	// delete file
+	err = d.call(ctx, "delete file", func() error {
+		return d.Service.Files.Delete(id).Context(ctx).Do()
+	})
+	if err != nil {
+		return fmt.Errorf("Error Delete File: %w", err)
+	}
+	fmt.Println("File deleted with id: ", id)
+
+	return nil
+}
+
+func (d *gdrive) Stat(ctx context.Context, id string) (*storage.Object, error) {
+	var file *drive.File
+	err := d.call(ctx, "get file", func() (err error) {
+		file, err = d.Service.Files.Get(id).Fields(fileFields).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Get File: %w", err)
+	}
+
+	return toObject(file), nil
+}
+
+// Move rename the file and re-parent it with Files.Update, the id and the revisions stay the same
+func (d *gdrive) Move(ctx context.Context, id, folderID, name string) (*storage.Object, error) {
+	var file *drive.File
+	err := d.call(ctx, "get file", func() (err error) {
+		file, err = d.Service.Files.Get(id).Fields("parents").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Get File: %w", err)
+	}
+
+	call := d.Service.Files.Update(id, &drive.File{Name: name}).Fields(fileFields).Context(ctx)
+	if len(file.Parents) != 1 || file.Parents[0] != folderID {
+		call = call.AddParents(folderID).RemoveParents(strings.Join(file.Parents, ","))
+	}
+
+	err = d.call(ctx, "move file", func() (err error) {
+		file, err = call.Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Move File: %w", err)
+	}
+
+	return toObject(file), nil
+}
+
+// Shortcut create a drive shortcut to the file, it does not use the storage quota
+func (d *gdrive) Shortcut(ctx context.Context, folderID, name, targetID string) (*storage.Object, error) {
+	shortcut := &drive.File{
+		Name:            name,
+		MimeType:        shortcutMimeType,
+		Parents:         []string{folderID},
+		ShortcutDetails: &drive.FileShortcutDetails{TargetId: targetID},
+	}
+
+	var file *drive.File
+	err := d.call(ctx, "create shortcut", func() (err error) {
+		file, err = d.Service.Files.Create(shortcut).Fields(fileFields).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Create Shortcut: %w", err)
+	}
+
+	return toObject(file), nil
+}
+
+// List return the files and folders inside folderID, trashed files are skipped
+func (d *gdrive) List(ctx context.Context, folderID string) ([]*storage.Object, error) {
+	if folderID == "" {
+		folderID = "root"
+	}
+
+	query := fmt.Sprintf("'%s' in parents and trashed=false", escapeQuery(folderID))
+
+	var objects []*storage.Object
+	pageToken := ""
+	for {
+		var list *drive.FileList
+		err := d.call(ctx, "list files", func() (err error) {
+			list, err = d.Service.Files.List().Q(query).Fields("nextPageToken, files(" + fileFields + ")").PageSize(1000).PageToken(pageToken).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error List Files: %w", err)
+		}
+
+		for _, file := range list.Files {
+			objects = append(objects, toObject(file))
+		}
+
+		if list.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// Share give writer permission to email, the existing permission of the email is returned if already there
+func (d *gdrive) Share(ctx context.Context, folderID, email string) (string, error) {
+	var permissions *drive.PermissionList
+	err := d.call(ctx, "list permissions", func() (err error) {
+		permissions, err = d.Service.Permissions.List(folderID).
+			Fields("permissions(id, role, type, emailAddress)").
+			SupportsAllDrives(true).
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error listing permissions: %w", err)
+	}
+
+	for _, perm := range permissions.Permissions {
+		if strings.EqualFold(perm.EmailAddress, email) && (perm.Role == "writer" || perm.Role == "owner" || perm.Role == "organizer") {
+			return perm.Id, nil
+		}
+	}
+
+	return d.createPermission(ctx, folderID, email)
+}
+
+func toObject(file *drive.File) *storage.Object {
+	obj := &storage.Object{
+		ID:       file.Id,
+		Name:     file.Name,
+		Size:     file.Size,
+		MimeType: file.MimeType,
+		MD5:      file.Md5Checksum,
+		IsFolder: file.MimeType == folderMimeType,
+		Trashed:  file.Trashed,
+	}
+
+	if len(file.Parents) > 0 {
+		obj.ParentID = file.Parents[0]
+	}
+
+	if t, err := time.Parse(time.RFC3339, file.ModifiedTime); err == nil {
+		obj.ModTime = t
+	}
+
+	return obj
+}
+
+// escapeQuery escape the value used inside single quote on drive query string
+func escapeQuery(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, `'`, `\'`)
+}
@@ -0,0 +1,126 @@
+package imagehash
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"os"
+	"strconv"
+
+	// decoders of the screenshot formats, other formats return image.ErrFormat
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// the hash compare 9x8 cells, 8 per row gives 64 bits
+const (
+	cols = 9
+	rows = 8
+)
+
+// at most this many pixels per cell side are read, so a 4K screenshot is hashed as fast as a small one
+const maxSamples = 64
+
+// DHash is the difference hash of an image: one bit per cell, set when the cell is brighter than its right
+// neighbor. resizing, compression and small edits change a few bits, another screen changes about half of them
+type DHash uint64
+
+// File decode the image and return its dhash
+func File(path string) (DHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+
+	return Hash(img), nil
+}
+
+// Hash return the dhash of the image
+func Hash(img image.Image) DHash {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	var cells [rows][cols]float64
+	for y := 0; y < rows; y++ {
+		y0, y1 := span(y, rows, height)
+		for x := 0; x < cols; x++ {
+			x0, x1 := span(x, cols, width)
+			cells[y][x] = brightness(img, b.Min.X+x0, b.Min.X+x1, b.Min.Y+y0, b.Min.Y+y1)
+		}
+	}
+
+	var hash DHash
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols-1; x++ {
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1 << (y*(cols-1) + x)
+			}
+		}
+	}
+
+	return hash
+}
+
+// span return the pixels [start, end) of the cell i of n on size pixels, never empty
+func span(i, n, size int) (int, int) {
+	start := i * size / n
+	end := (i + 1) * size / n
+	if end <= start {
+		end = start + 1
+	}
+	if end > size {
+		start, end = max(size-1, 0), max(size, 1)
+	}
+	return start, end
+}
+
+// brightness return the average gray of the sampled pixels of the rectangle
+func brightness(img image.Image, x0, x1, y0, y1 int) float64 {
+	stepX := max((x1-x0)/maxSamples, 1)
+	stepY := max((y1-y0)/maxSamples, 1)
+
+	var sum float64
+	count := 0
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			sum += float64(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+
+	return sum / float64(count)
+}
+
+// Distance is the number of different bits, 0 for the same picture and up to 64
+func Distance(a, b DHash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// String return the hash as 16 hex digits, the format stored on the records table
+func (h DHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Parse read the hash written by String
+func Parse(s string) (DHash, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("invalid image hash '%s': want 16 hex digits", s)
+	}
+
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid image hash '%s': %v", s, err)
+	}
+	return DHash(v), nil
+}
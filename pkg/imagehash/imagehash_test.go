@@ -0,0 +1,127 @@
+package imagehash
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the default dedupe.similar.distance
+const defaultDistance = 5
+
+// screen return a 320x200 picture of 20px gray blocks from the seed, every pixel brighter by shift
+func screen(seed int64, shift int) *image.RGBA {
+	r := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, 320, 200))
+
+	for by := 0; by < 10; by++ {
+		for bx := 0; bx < 16; bx++ {
+			v := min(max(r.Intn(256)+shift, 0), 255)
+			c := color.RGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 255}
+			for y := by * 20; y < (by+1)*20; y++ {
+				for x := bx * 20; x < (bx+1)*20; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b image.Image
+		// distance is at most max and at least min
+		min, max int
+	}{
+		{name: "identical", a: screen(1, 0), b: screen(1, 0), min: 0, max: 0},
+		{name: "brighter", a: screen(1, 0), b: screen(1, 10), min: 0, max: defaultDistance},
+		{name: "darker", a: screen(1, 0), b: screen(1, -10), min: 0, max: defaultDistance},
+		{name: "other screen", a: screen(1, 0), b: screen(2, 0), min: defaultDistance + 1, max: 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Distance(Hash(tt.a), Hash(tt.b))
+			if d < tt.min || d > tt.max {
+				t.Fatalf("Distance() = %d, want between %d and %d", d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestDistanceBits(t *testing.T) {
+	tests := []struct {
+		a, b DHash
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xff, 0x0f, 4},
+		{0, ^DHash(0), 64},
+	}
+
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want || Distance(tt.b, tt.a) != tt.want {
+			t.Fatalf("Distance(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	img := screen(1, 0)
+	p := filepath.Join(t.TempDir(), "a.png")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	hash, err := File(p)
+	if err != nil {
+		t.Fatalf("File() error: %v", err)
+	}
+	if hash != Hash(img) {
+		t.Fatalf("File() = %s, want the hash of the image %s", hash, Hash(img))
+	}
+
+	text := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(text, []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := File(text); err != image.ErrFormat {
+		t.Fatalf("File() of a text file error = %v, want image.ErrFormat", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, h := range []DHash{0, 1, 0x8000000000000000, ^DHash(0), Hash(screen(1, 0))} {
+		got, err := Parse(h.String())
+		if err != nil || got != h {
+			t.Fatalf("Parse(%q) = %s, %v, want %s", h.String(), got, err, h)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"ff",
+		"00000000000000ff0",
+		"0x0000000000000f",
+		"zzzzzzzzzzzzzzzz",
+		"+000000000000000",
+		" 000000000000000",
+	} {
+		if h, err := Parse(s); err == nil {
+			t.Fatalf("Parse(%q) = %s, want error", s, h)
+		}
+	}
+}
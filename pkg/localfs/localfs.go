@@ -0,0 +1,362 @@
+package localfs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// LocalFS is the local disk / mounted NAS backend. the id of every file and folder is its absolute path,
+// so the records table store the destination path
+type LocalFS interface {
+	storage.Storage
+}
+
+type Config struct {
+	// destination root folder, must already exist so an unmounted NAS share is not silently filled on the local disk
+	Root string
+}
+
+type localFS struct {
+	root string
+}
+
+func NewLocalFS(cfg Config) (LocalFS, error) {
+	if cfg.Root == "" {
+		return nil, fmt.Errorf("Local root path is empty")
+	}
+
+	root, err := filepath.Abs(cfg.Root)
+	if err != nil {
+		return nil, fmt.Errorf("Error Resolve Root Path: %v", err)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("Error Check Root Path: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Local root '%s' is not a directory", root)
+	}
+
+	fmt.Println("Local storage ready: ", root)
+
+	return &localFS{
+		root: root,
+	}, nil
+}
+
+func (l *localFS) Kind() string {
+	return "local"
+}
+
+func (l *localFS) EnsureFolder(ctx context.Context, parentID, name string) (string, error) {
+	if parentID == "" {
+		parentID = l.root
+	}
+
+	dir, err := l.resolve(filepath.Join(parentID, name))
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return "", fmt.Errorf("Error creating folder: '%s' is a file", dir)
+		}
+		return dir, nil
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("Error creating folder: %v", err)
+	}
+
+	// the new entry only survive power loss when the parent is synced too
+	if err := syncDir(filepath.Dir(dir)); err != nil {
+		return "", fmt.Errorf("Error Sync Folder: %v", err)
+	}
+
+	fmt.Printf("Folder %s created \n", dir)
+
+	return dir, nil
+}
+
+// Put copy the file to a temp file on the destination folder, fsync and rename it,
+// so the destination is never seen half written even when the copy is interrupted
+func (l *localFS) Put(ctx context.Context, folderID, name, localPath, mimeType string) (*storage.Object, error) {
+	dest, err := l.resolve(filepath.Join(folderID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error Open File: %v", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Error Stat File: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".ss-watcher-*.tmp")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Error Create Temp File '%s': %w", folderID, storage.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Create Temp File: %v", err)
+	}
+	// no-op after the rename
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), storage.ContextReader(ctx, src))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error Copy File: %v", err)
+	}
+
+	// keep the capture time on the copy
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return nil, fmt.Errorf("Error Set File Time: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return nil, fmt.Errorf("Error Rename File: %v", err)
+	}
+
+	if err := syncDir(filepath.Dir(dest)); err != nil {
+		return nil, fmt.Errorf("Error Sync Folder: %v", err)
+	}
+
+	return &storage.Object{
+		ID:       dest,
+		Name:     name,
+		ParentID: folderID,
+		Size:     info.Size(),
+		MimeType: mimeType,
+		MD5:      hex.EncodeToString(hash.Sum(nil)),
+		ModTime:  info.ModTime(),
+	}, nil
+}
+
+func (l *localFS) Delete(ctx context.Context, id string) error {
+	p, err := l.resolve(id)
+	if err != nil {
+		return err
+	}
+
+	// no Stat, it would hash the whole file only to check it
+	info, err := os.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Error Get File '%s': %w", p, storage.ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("Error Get File: %v", err)
+	}
+
+	// this function cannt delete folder
+	if info.IsDir() {
+		return fmt.Errorf("Cannot delete folder")
+	}
+
+	if err := os.Remove(p); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Error Delete File '%s': %w", p, storage.ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("Error Delete File: %v", err)
+	}
+
+	if err := syncDir(filepath.Dir(p)); err != nil {
+		return fmt.Errorf("Error Sync Folder: %v", err)
+	}
+
+	fmt.Println("File deleted with path: ", p)
+
+	return nil
+}
+
+func (l *localFS) Move(ctx context.Context, id, folderID, name string) (*storage.Object, error) {
+	src, err := l.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := l.resolve(filepath.Join(folderID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(src, dest); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Error Move File '%s': %w", src, storage.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Move File: %v", err)
+	}
+
+	for _, dir := range []string{filepath.Dir(src), filepath.Dir(dest)} {
+		if err := syncDir(dir); err != nil {
+			return nil, fmt.Errorf("Error Sync Folder: %v", err)
+		}
+	}
+
+	return l.Stat(ctx, dest)
+}
+
+// Shortcut create a hard link to the file, the content is stored once and deleting one name keep the other.
+// the file already on the name is replaced like on Put
+func (l *localFS) Shortcut(ctx context.Context, folderID, name, targetID string) (*storage.Object, error) {
+	src, err := l.resolve(targetID)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := l.resolve(filepath.Join(folderID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	if src != dest {
+		if err := os.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("Error Replace File: %v", err)
+		}
+
+		if err := os.Link(src, dest); errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("Error Link File '%s': %w", src, storage.ErrNotFound)
+		} else if err != nil {
+			return nil, fmt.Errorf("Error Link File: %v", err)
+		}
+
+		if err := syncDir(filepath.Dir(dest)); err != nil {
+			return nil, fmt.Errorf("Error Sync Folder: %v", err)
+		}
+	}
+
+	return l.Stat(ctx, dest)
+}
+
+// Stat also hash the file, reading a local copy is cheap compared to the upload
+func (l *localFS) Stat(ctx context.Context, id string) (*storage.Object, error) {
+	p, err := l.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Error Get File '%s': %w", p, storage.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Get File: %v", err)
+	}
+
+	obj := &storage.Object{
+		ID:       p,
+		Name:     filepath.Base(p),
+		ParentID: filepath.Dir(p),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		IsFolder: info.IsDir(),
+	}
+
+	if !obj.IsFolder {
+		if obj.MD5, err = fileMD5(p); err != nil {
+			return nil, fmt.Errorf("Error Hash File: %v", err)
+		}
+	}
+
+	return obj, nil
+}
+
+// List does not hash the files, use Stat for the md5
+func (l *localFS) List(ctx context.Context, folderID string) ([]*storage.Object, error) {
+	p, err := l.resolve(folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Error List Folder '%s': %w", p, storage.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error List Folder: %v", err)
+	}
+
+	objects := make([]*storage.Object, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// removed while listing
+			continue
+		}
+
+		objects = append(objects, &storage.Object{
+			ID:       filepath.Join(p, entry.Name()),
+			Name:     entry.Name(),
+			ParentID: p,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			IsFolder: entry.IsDir(),
+		})
+	}
+
+	return objects, nil
+}
+
+// Share has no meaning on a local folder, access is managed by the file system / NAS
+func (l *localFS) Share(ctx context.Context, folderID, email string) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+// resolve clean the path and make sure it is inside the root
+func (l *localFS) resolve(p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(l.root, p)
+	}
+	p = filepath.Clean(p)
+
+	rel, err := filepath.Rel(l.root, p)
+	if err != nil || rel == ".." || (len(rel) > 2 && rel[:3] == ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path '%s' is outside the root '%s'", p, l.root)
+	}
+
+	return p, nil
+}
+
+func fileMD5(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// syncDir flush the directory entry, windows cannot open a directory for sync
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,301 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+func newTestLocalFS(t *testing.T) (*localFS, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	l, err := NewLocalFS(Config{Root: root})
+	if err != nil {
+		t.Fatalf("NewLocalFS() error: %v", err)
+	}
+
+	return l.(*localFS), root
+}
+
+func writeFile(t *testing.T, p, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func readFile(t *testing.T, p string) string {
+	t.Helper()
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNewLocalFS(t *testing.T) {
+	file := writeFile(t, filepath.Join(t.TempDir(), "a.png"), "x")
+
+	tests := []struct {
+		name string
+		root string
+	}{
+		{"empty", ""},
+		{"missing", filepath.Join(t.TempDir(), "nas")},
+		{"file", file},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewLocalFS(Config{Root: tt.root}); err == nil {
+				t.Fatalf("NewLocalFS(%q): want error", tt.root)
+			}
+		})
+	}
+}
+
+func TestPut(t *testing.T) {
+	ctx := context.Background()
+	l, root := newTestLocalFS(t)
+
+	modTime := time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)
+	src := writeFile(t, filepath.Join(t.TempDir(), "a.png"), "first")
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := l.Put(ctx, root, "a.png", src, "image/png")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	dest := filepath.Join(root, "a.png")
+	if obj.ID != dest || obj.Size != 5 || obj.MD5 != "8b04d5e3775d298e78455efc5ca404d5" {
+		t.Fatalf("Put() = %+v", obj)
+	}
+	if got := readFile(t, dest); got != "first" {
+		t.Fatalf("content = %q", got)
+	}
+	if info, _ := os.Stat(dest); !info.ModTime().Equal(modTime) {
+		t.Fatalf("mod time = %s, want the time of the source", info.ModTime())
+	}
+
+	// a second name of the old file keep the old content: the new content is a new file renamed over the name,
+	// not written in place where a reader could see it half written
+	keep := filepath.Join(root, "keep.png")
+	if err := os.Link(dest, keep); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, src, "second")
+	if _, err := l.Put(ctx, root, "a.png", src, "image/png"); err != nil {
+		t.Fatalf("Put() overwrite error: %v", err)
+	}
+	if got := readFile(t, dest); got != "second" {
+		t.Fatalf("overwritten content = %q", got)
+	}
+	if got := readFile(t, keep); got != "first" {
+		t.Fatalf("old file content = %q, want it untouched", got)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Fatalf("temp file %s left on the destination", entry.Name())
+		}
+	}
+
+	if _, err := l.Put(ctx, filepath.Join(root, "missing"), "a.png", src, "image/png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Put() on missing folder error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		folder  string
+		newName string
+		wantErr error
+	}{
+		{name: "rename", folder: ".", newName: "b.png"},
+		{name: "other folder", folder: "sub", newName: "a.png"},
+		{name: "missing folder", folder: "missing", newName: "a.png", wantErr: storage.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, root := newTestLocalFS(t)
+			src := writeFile(t, filepath.Join(root, "a.png"), "shot")
+			if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			folder := filepath.Join(root, tt.folder)
+			obj, err := l.Move(ctx, src, folder, tt.newName)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Move() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Move() error: %v", err)
+			}
+
+			dest := filepath.Join(folder, tt.newName)
+			if obj.ID != dest || obj.Name != tt.newName {
+				t.Fatalf("Move() = %+v, want %s", obj, dest)
+			}
+			if got := readFile(t, dest); got != "shot" {
+				t.Fatalf("moved content = %q", got)
+			}
+			if _, err := os.Stat(src); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("source still there: %v", err)
+			}
+		})
+	}
+
+	l, root := newTestLocalFS(t)
+	if _, err := l.Move(ctx, filepath.Join(root, "gone.png"), root, "b.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Move() of missing file error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestShortcut(t *testing.T) {
+	ctx := context.Background()
+	l, root := newTestLocalFS(t)
+
+	original := writeFile(t, filepath.Join(root, "a.png"), "shot")
+	// the file already on the name is replaced
+	writeFile(t, filepath.Join(root, "sub", "b.png"), "old")
+
+	obj, err := l.Shortcut(ctx, filepath.Join(root, "sub"), "b.png", original)
+	if err != nil {
+		t.Fatalf("Shortcut() error: %v", err)
+	}
+
+	a, _ := os.Stat(original)
+	b, _ := os.Stat(obj.ID)
+	if !os.SameFile(a, b) {
+		t.Fatal("shortcut is not a hard link of the original")
+	}
+
+	// deleting the original keep the content on the shortcut
+	if err := l.Delete(ctx, original); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if got := readFile(t, obj.ID); got != "shot" {
+		t.Fatalf("shortcut content = %q", got)
+	}
+
+	if _, err := l.Shortcut(ctx, root, "c.png", original); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Shortcut() to missing file error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+		// the error is not one of the storage errors, only checked to be non nil
+		anyErr bool
+	}{
+		{name: "file", id: "a.png"},
+		{name: "folder", id: "sub", anyErr: true},
+		{name: "missing", id: "gone.png", wantErr: storage.ErrNotFound},
+		{name: "outside root", id: "../a.png", anyErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, root := newTestLocalFS(t)
+			writeFile(t, filepath.Join(root, "a.png"), "shot")
+			writeFile(t, filepath.Join(root, "sub", "b.png"), "shot")
+			// same name next to the root, must not be touched
+			outside := writeFile(t, filepath.Join(filepath.Dir(root), "a.png"), "outside")
+
+			err := l.Delete(ctx, tt.id)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Delete(%q) error = %v, want %v", tt.id, err, tt.wantErr)
+				}
+			case tt.anyErr:
+				if err == nil || errors.Is(err, storage.ErrNotFound) {
+					t.Fatalf("Delete(%q) error = %v, want a refusal", tt.id, err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Delete(%q) error: %v", tt.id, err)
+				}
+				if _, err := os.Stat(filepath.Join(root, tt.id)); !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("file still there: %v", err)
+				}
+			}
+
+			if _, err := os.Stat(filepath.Join(root, "sub")); err != nil {
+				t.Fatalf("folder removed: %v", err)
+			}
+			if got := readFile(t, outside); got != "outside" {
+				t.Fatalf("file outside the root changed: %q", got)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	l, root := newTestLocalFS(t)
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "a.png", want: filepath.Join(root, "a.png")},
+		{id: "SS_2024-03-09/a.png", want: filepath.Join(root, "SS_2024-03-09", "a.png")},
+		{id: filepath.Join(root, "sub", "..", "a.png"), want: filepath.Join(root, "a.png")},
+		{id: root, want: root},
+		// a name that only start with two dots is inside
+		{id: "..a.png", want: filepath.Join(root, "..a.png")},
+		{id: "..", wantErr: true},
+		{id: "../a.png", wantErr: true},
+		{id: "sub/../../a.png", wantErr: true},
+		{id: filepath.Join(root, "..", "a.png"), wantErr: true},
+		{id: filepath.Dir(root), wantErr: true},
+		// the root name as a prefix of a sibling folder
+		{id: root + "-other/a.png", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got, err := l.resolve(tt.id)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolve(%q) = %q, want error", tt.id, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("resolve(%q) = %q, %v, want %q", tt.id, got, err, tt.want)
+			}
+		})
+	}
+}
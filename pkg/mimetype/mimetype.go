@@ -0,0 +1,138 @@
+package mimetype
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// used when nothing else match
+const Default = "application/octet-stream"
+
+// number of bytes read for sniffing, same as http.DetectContentType
+const sniffLen = 512
+
+// extension fallback for the types that mime.TypeByExtension does not know on every OS
+var extensions = map[string]string{
+	".png":        "image/png",
+	".jpg":        "image/jpeg",
+	".jpeg":       "image/jpeg",
+	".gif":        "image/gif",
+	".webp":       "image/webp",
+	".bmp":        "image/bmp",
+	".tif":        "image/tiff",
+	".tiff":       "image/tiff",
+	".heic":       "image/heic",
+	".heif":       "image/heif",
+	".avif":       "image/avif",
+	".svg":        "image/svg+xml",
+	".mp4":        "video/mp4",
+	".m4v":        "video/mp4",
+	".mov":        "video/quicktime",
+	".webm":       "video/webm",
+	".mkv":        "video/x-matroska",
+	".avi":        "video/x-msvideo",
+	".pdf":        "application/pdf",
+	".drawio":     "application/vnd.jgraph.mxfile",
+	".excalidraw": "application/json",
+}
+
+// brand of the ISO base media "ftyp" box, http.DetectContentType only know some of the mp4 brands
+var ftypBrands = map[string]string{
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"iso4": "video/mp4",
+	"iso5": "video/mp4",
+	"iso6": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+	"dash": "video/mp4",
+	"M4V ": "video/mp4",
+	"M4A ": "audio/mp4",
+	"qt  ": "video/quicktime",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"mif1": "image/heif",
+	"msf1": "image/heif",
+	"avif": "image/avif",
+	"avis": "image/avif",
+}
+
+// Detect return the mime type of the file: override by extension first, then the content (magic bytes),
+// and the extension when the content is not recognized. overrides key is the lower case extension with the dot
+func Detect(path string, overrides map[string]string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if t, ok := overrides[ext]; ok && t != "" {
+		return t, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return FromContent(head[:n], ext), nil
+}
+
+// FromContent detect the type from the first bytes of the file, ext is the fallback when the content is generic
+func FromContent(head []byte, ext string) string {
+	if t := sniffFtyp(head); t != "" {
+		return t
+	}
+
+	sniffed := stripParams(http.DetectContentType(head))
+
+	// generic result, the extension know better (ex: svg is sniffed as text/xml, docs as zip)
+	if sniffed == Default || strings.HasPrefix(sniffed, "text/") || sniffed == "application/zip" {
+		if t := FromExtension(ext); t != "" {
+			return t
+		}
+	}
+
+	return sniffed
+}
+
+// FromExtension return the type of the extension (with the dot), empty when unknown
+func FromExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if t, ok := extensions[ext]; ok {
+		return t
+	}
+
+	if t := mime.TypeByExtension(ext); t != "" {
+		return stripParams(t)
+	}
+
+	return ""
+}
+
+// sniffFtyp read the major brand of ISO base media file (mp4, mov, heic, avif)
+func sniffFtyp(head []byte) string {
+	if len(head) < 12 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return ""
+	}
+
+	return ftypBrands[string(head[8:12])]
+}
+
+func stripParams(t string) string {
+	mediaType, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return t
+	}
+	return mediaType
+}
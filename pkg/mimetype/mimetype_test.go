@@ -0,0 +1,119 @@
+package mimetype
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ftyp return the start of an ISO base media file with the major brand and the compatible brands
+func ftyp(major string, compatible ...string) []byte {
+	box := []byte("ftyp" + major + "\x00\x00\x02\x00")
+	for _, brand := range compatible {
+		box = append(box, brand...)
+	}
+	size := len(box) + 4
+	return append([]byte{0, 0, byte(size >> 8), byte(size)}, box...)
+}
+
+var (
+	png  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x00")
+	jpeg = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01")
+	webp = []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00")
+	svg  = []byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`)
+)
+
+func TestFromContent(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		ext  string
+		want string
+	}{
+		{"png", png, ".png", "image/png"},
+		{"jpeg", jpeg, ".jpg", "image/jpeg"},
+		{"webp", webp, ".webp", "image/webp"},
+		{"mp4 isom", ftyp("isom", "isom", "iso2", "avc1", "mp41"), ".mp4", "video/mp4"},
+		{"mp4 mp42", ftyp("mp42", "mp42", "isom"), ".mp4", "video/mp4"},
+		{"mov", ftyp("qt  ", "qt  "), ".mov", "video/quicktime"},
+		{"heic", ftyp("heic", "mif1", "heic"), ".heic", "image/heic"},
+		{"heif", ftyp("mif1", "mif1"), ".heif", "image/heif"},
+		{"avif", ftyp("avif", "avif", "mif1"), ".avif", "image/avif"},
+
+		// the content win over a wrong extension
+		{"png named jpg", png, ".jpg", "image/png"},
+		{"mov named mp4", ftyp("qt  "), ".mp4", "video/quicktime"},
+		{"mp4 without extension", ftyp("isom"), "", "video/mp4"},
+
+		// generic content, the extension know better
+		{"svg as xml", svg, ".svg", "image/svg+xml"},
+		{"svg without xml declaration", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ".svg", "image/svg+xml"},
+		{"svg without extension", svg, "", "text/xml"},
+		{"json drawing", []byte(`{"type":"excalidraw","version":2}`), ".excalidraw", "application/json"},
+		{"upper case extension", []byte{0x01, 0x02, 0x03}, ".PNG", "image/png"},
+		{"unknown", []byte{0x01, 0x02, 0x03}, ".unknown-ext", Default},
+		{"empty file", nil, ".unknown-ext", "text/plain"},
+
+		// the box must be a ftyp with a known brand
+		{"unknown brand", ftyp("zzzz"), ".unknown-ext", Default},
+		{"short ftyp", []byte("\x00\x00\x00\x08ftyp"), ".mov", "video/quicktime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromContent(tt.head, tt.ext); got != tt.want {
+				t.Fatalf("FromContent(%q) = %q, want %q", tt.ext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	overrides := map[string]string{
+		".png":  "image/x-screenshot",
+		".mov":  "",
+		".blob": "application/x-blob",
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		overrides map[string]string
+		want      string
+	}{
+		{"content", write("a.png", png), nil, "image/png"},
+		{"larger than the sniffed part", write("b.mp4", append(ftyp("isom"), make([]byte, 4096)...)), nil, "video/mp4"},
+		// the override is used before the content
+		{"override", write("c.png", png), overrides, "image/x-screenshot"},
+		{"override upper case extension", write("d.PNG", png), overrides, "image/x-screenshot"},
+		{"override of other type", write("e.jpg", jpeg), overrides, "image/jpeg"},
+		{"empty override", write("f.mov", ftyp("qt  ")), overrides, "video/quicktime"},
+		{"override of unknown content", write("g.blob", []byte{0x01, 0x02}), overrides, "application/x-blob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Detect(tt.path, tt.overrides)
+			if err != nil || got != tt.want {
+				t.Fatalf("Detect(%s) = %q, %v, want %q", filepath.Base(tt.path), got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Detect(filepath.Join(dir, "missing.png"), nil); err == nil {
+		t.Fatal("Detect() of a missing file: want error")
+	}
+	// the override does not need the file
+	if got, err := Detect(filepath.Join(dir, "missing.png"), overrides); err != nil || got != "image/x-screenshot" {
+		t.Fatalf("Detect() of a missing file with override = %q, %v", got, err)
+	}
+}
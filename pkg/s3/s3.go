@@ -0,0 +1,339 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// default layout of the daily folder, same as the drive one without the random suffix
+const DefaultDailyTemplate = "{prefix}{yyyy}-{mm}-{dd}"
+
+// max expiry of sigv4 presigned url
+const maxLinkExpiry = 7 * 24 * time.Hour
+
+// S3 is the S3 compatible object storage backend (AWS S3, MinIO, ...). folders are only key prefixes,
+// so the id of every object and folder is its key
+type S3 interface {
+	storage.Storage
+	storage.Linker
+}
+
+type Config struct {
+	// host[:port] without scheme, default s3.amazonaws.com
+	Endpoint string
+	Region   string
+	Bucket   string
+	// prefix of every key, ex: "team-a/screenshots"
+	Prefix string
+	// empty keys use the env (AWS_ACCESS_KEY_ID, MINIO_ROOT_USER, ...), the aws credentials file and then IAM
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// plain http, for local MinIO
+	Insecure bool
+	// bucket on the path instead of the host name, needed by most MinIO setup
+	PathStyle bool
+	// layout of the daily folder, placeholders: {prefix} {yyyy} {mm} {dd} {date}, can contain slash
+	DailyTemplate string
+	// server side encryption: "" (bucket default), "AES256" (SSE-S3) or "aws:kms" (SSE-KMS)
+	SSE         string
+	SSEKMSKeyID string
+	// expiry of the presigned share link, default and max 7 days
+	LinkExpiry time.Duration
+}
+
+type s3Storage struct {
+	client *minio.Client
+	cfg    Config
+	sse    encrypt.ServerSide
+}
+
+func NewS3(cfg Config) (S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is empty")
+	}
+
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "s3.amazonaws.com"
+	}
+
+	if cfg.DailyTemplate == "" {
+		cfg.DailyTemplate = DefaultDailyTemplate
+	}
+
+	if cfg.LinkExpiry <= 0 || cfg.LinkExpiry > maxLinkExpiry {
+		cfg.LinkExpiry = maxLinkExpiry
+	}
+
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	sse, err := newSSE(cfg.SSE, cfg.SSEKMSKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, cfg.SessionToken)
+	if cfg.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !cfg.Insecure,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error creating S3 client: %v", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking S3 bucket: %v", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("S3 bucket '%s' does not exist", cfg.Bucket)
+	}
+
+	fmt.Println("S3 bucket connected successfully: ", cfg.Bucket)
+
+	return &s3Storage{
+		client: client,
+		cfg:    cfg,
+		sse:    sse,
+	}, nil
+}
+
+func newSSE(mode, kmsKeyID string) (encrypt.ServerSide, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "AES256":
+		return encrypt.NewSSE(), nil
+	case "aws:kms":
+		sse, err := encrypt.NewSSEKMS(kmsKeyID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error S3 SSE-KMS: %v", err)
+		}
+		return sse, nil
+	default:
+		return nil, fmt.Errorf("unknown S3 sse '%s', use AES256 or aws:kms", mode)
+	}
+}
+
+func (s *s3Storage) Kind() string {
+	return "s3"
+}
+
+// EnsureFolder only build the key prefix, object storage does not need the folder to exist
+func (s *s3Storage) EnsureFolder(ctx context.Context, parentID, name string) (string, error) {
+	if parentID == "" {
+		parentID = s.cfg.Prefix
+	}
+
+	return joinKey(parentID, name), nil
+}
+
+func (s *s3Storage) EnsureDailyFolder(ctx context.Context, baseID, prefix string, day time.Time) (string, error) {
+	name := strings.NewReplacer(
+		"{prefix}", prefix,
+		"{yyyy}", day.Format("2006"),
+		"{mm}", day.Format("01"),
+		"{dd}", day.Format("02"),
+		"{date}", day.Format("2006-01-02"),
+	).Replace(s.cfg.DailyTemplate)
+
+	return joinKey(baseID, name), nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, folderID, name, localPath, mimeType string) (*storage.Object, error) {
+	key := joinKey(folderID, name)
+
+	info, err := s.client.FPutObject(ctx, s.cfg.Bucket, key, localPath, minio.PutObjectOptions{
+		ContentType:          mimeType,
+		ServerSideEncryption: s.sse,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Upload File: %w", unavailable(err))
+	}
+
+	return &storage.Object{
+		ID:       key,
+		Name:     name,
+		ParentID: folderID,
+		Size:     info.Size,
+		MimeType: mimeType,
+		MD5:      s.etagMD5(info.ETag),
+		ModTime:  info.LastModified,
+	}, nil
+}
+
+// Delete remove the object, RemoveObject succeed for missing key so it is checked first
+func (s *s3Storage) Delete(ctx context.Context, id string) error {
+	if _, err := s.statObject(ctx, id); err != nil {
+		return err
+	}
+
+	if err := s.client.RemoveObject(ctx, s.cfg.Bucket, id, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("Error Delete File: %w", unavailable(err))
+	}
+	fmt.Println("File deleted with key: ", id)
+
+	return nil
+}
+
+// Move copy the object to the new key on the server side and remove the old one, object storage has no rename
+func (s *s3Storage) Move(ctx context.Context, id, folderID, name string) (*storage.Object, error) {
+	if _, err := s.statObject(ctx, id); err != nil {
+		return nil, err
+	}
+
+	key := joinKey(folderID, name)
+	if key == id {
+		return s.statObject(ctx, key)
+	}
+
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.cfg.Bucket, Object: key, Encryption: s.sse},
+		minio.CopySrcOptions{Bucket: s.cfg.Bucket, Object: id},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error Copy File: %w", unavailable(err))
+	}
+
+	if err := s.client.RemoveObject(ctx, s.cfg.Bucket, id, minio.RemoveObjectOptions{}); err != nil {
+		return nil, fmt.Errorf("Error Delete File: %w", unavailable(err))
+	}
+
+	return s.statObject(ctx, key)
+}
+
+// Stat return the object, or a folder when the key is a prefix of other objects
+func (s *s3Storage) Stat(ctx context.Context, id string) (*storage.Object, error) {
+	obj, err := s.statObject(ctx, id)
+	if err == nil || !errors.Is(err, storage.ErrNotFound) {
+		return obj, err
+	}
+
+	opts := minio.ListObjectsOptions{Prefix: strings.TrimSuffix(id, "/") + "/", MaxKeys: 1}
+	for item := range s.client.ListObjects(ctx, s.cfg.Bucket, opts) {
+		if item.Err != nil {
+			return nil, fmt.Errorf("Error List Objects: %w", unavailable(item.Err))
+		}
+		return &storage.Object{ID: id, Name: path.Base(id), ParentID: path.Dir(id), IsFolder: true}, nil
+	}
+
+	return nil, err
+}
+
+// List return the objects and the "folders" (common prefix) directly under the folderID prefix
+func (s *s3Storage) List(ctx context.Context, folderID string) ([]*storage.Object, error) {
+	prefix := ""
+	if folderID != "" {
+		prefix = strings.TrimSuffix(folderID, "/") + "/"
+	}
+
+	var objects []*storage.Object
+	for item := range s.client.ListObjects(ctx, s.cfg.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if item.Err != nil {
+			return nil, fmt.Errorf("Error List Objects: %w", unavailable(item.Err))
+		}
+
+		// common prefix come with the trailing slash and no metadata
+		if strings.HasSuffix(item.Key, "/") {
+			key := strings.TrimSuffix(item.Key, "/")
+			objects = append(objects, &storage.Object{ID: key, Name: path.Base(key), ParentID: folderID, IsFolder: true})
+			continue
+		}
+
+		objects = append(objects, &storage.Object{
+			ID:       item.Key,
+			Name:     path.Base(item.Key),
+			ParentID: folderID,
+			Size:     item.Size,
+			MimeType: item.ContentType,
+			MD5:      s.etagMD5(item.ETag),
+			ModTime:  item.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+// Share cannot give access per email on object storage, use ShareLink (presigned url) instead
+func (s *s3Storage) Share(ctx context.Context, folderID, email string) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+// ShareLink return presigned GET url of the object, valid for LinkExpiry
+func (s *s3Storage) ShareLink(ctx context.Context, id string) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.cfg.Bucket, id, s.cfg.LinkExpiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("Error Presign URL: %v", err)
+	}
+
+	return u.String(), nil
+}
+
+func (s *s3Storage) statObject(ctx context.Context, key string) (*storage.Object, error) {
+	info, err := s.client.StatObject(ctx, s.cfg.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		resp := minio.ToErrorResponse(err)
+		if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("Error Get File: %w: %v", storage.ErrNotFound, err)
+		}
+		return nil, fmt.Errorf("Error Get File: %w", unavailable(err))
+	}
+
+	return &storage.Object{
+		ID:       key,
+		Name:     path.Base(key),
+		ParentID: path.Dir(key),
+		Size:     info.Size,
+		MimeType: info.ContentType,
+		MD5:      s.etagMD5(info.ETag),
+		ModTime:  info.LastModified,
+	}, nil
+}
+
+// etagMD5 return the etag when it is the md5 of the content, multipart upload and SSE-KMS etag is not
+func (s *s3Storage) etagMD5(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if s.cfg.SSE == "aws:kms" || len(etag) != 32 || strings.Contains(etag, "-") {
+		return ""
+	}
+	return etag
+}
+
+func joinKey(parts ...string) string {
+	return strings.Trim(path.Join(parts...), "/")
+}
+
+// unavailable mark the error of the server (5xx, slow down) with storage.ErrUnavailable, the network error is
+// returned as is, storage.IsUnavailable already know it
+func unavailable(err error) error {
+	if resp := minio.ToErrorResponse(err); resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.Code == "SlowDown" {
+		return fmt.Errorf("%w: %v", storage.ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,413 @@
+package s3
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+const testBucket = "shots"
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// fakeS3 is an in-process stand-in of the S3 api, only the calls made by the backend with path style bucket
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		_, srcKey, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+		obj, ok := f.objects[srcKey]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		copied := *obj
+		copied.modTime = time.Now().UTC()
+		f.objects[key] = &copied
+
+		type result struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			LastModified string
+			ETag         string
+		}
+		xml.NewEncoder(w).Encode(result{LastModified: copied.modTime.Format(time.RFC3339), ETag: etag(copied.data)})
+
+	case r.Method == http.MethodPut:
+		data, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = &fakeObject{data: data, contentType: r.Header.Get("Content-Type"), modTime: time.Now().UTC()}
+		w.Header().Set("ETag", etag(data))
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag(obj.data))
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list answer ListObjectsV2 with the delimiter, the keys under a sub prefix are a common prefix
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	type result struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		Delimiter      string
+		MaxKeys        int
+		KeyCount       int
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}
+
+	res := result{Name: testBucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+	seen := make(map[string]bool)
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			p := key[:len(prefix)+i+len(delimiter)]
+			if !seen[p] {
+				seen[p] = true
+				res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: p})
+			}
+			continue
+		}
+
+		obj := f.objects[key]
+		res.Contents = append(res.Contents, content{
+			Key:          key,
+			LastModified: obj.modTime.Format(time.RFC3339),
+			ETag:         etag(obj.data),
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+
+	xml.NewEncoder(w).Encode(res)
+}
+
+// readBody return the content of the upload, the chunks of the streaming signature are decoded
+func readBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	type errorResponse struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: code})
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func newTestS3(t *testing.T, template string) (S3, *fakeS3) {
+	t.Helper()
+
+	fake := &fakeS3{objects: make(map[string]*fakeObject)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	s, err := NewS3(Config{
+		Endpoint:      strings.TrimPrefix(srv.URL, "http://"),
+		Region:        "us-east-1",
+		Bucket:        testBucket,
+		Prefix:        "/team/",
+		AccessKey:     "test",
+		SecretKey:     "test-secret",
+		Insecure:      true,
+		PathStyle:     true,
+		DailyTemplate: template,
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error: %v", err)
+	}
+
+	return s, fake
+}
+
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewS3MissingBucket(t *testing.T) {
+	srv := httptest.NewServer(&fakeS3{objects: make(map[string]*fakeObject)})
+	defer srv.Close()
+
+	_, err := NewS3(Config{Endpoint: strings.TrimPrefix(srv.URL, "http://"), Region: "us-east-1", Bucket: "other", AccessKey: "test", SecretKey: "test-secret", Insecure: true, PathStyle: true})
+	if err == nil {
+		t.Fatal("NewS3() with a missing bucket: want error")
+	}
+}
+
+func TestEnsureDailyFolder(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 9, 15, 0, 0, 0, time.UTC)
+
+	s, _ := newTestS3(t, "")
+	base, err := s.EnsureFolder(ctx, "", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != "team/backup" {
+		t.Fatalf("EnsureFolder() = %q, want %q", base, "team/backup")
+	}
+
+	daily, err := storage.EnsureDailyFolder(ctx, s, base, "SS_", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if daily != "team/backup/SS_2024-03-09" {
+		t.Fatalf("EnsureDailyFolder() = %q, want %q", daily, "team/backup/SS_2024-03-09")
+	}
+}
+
+func TestDailyTemplate(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 9, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{yyyy}/{mm}/{dd}", "team/backup/2024/03/09"},
+		{"{prefix}{date}", "team/backup/SS_2024-03-09"},
+		{"/{prefix}/{yyyy}-{mm}/", "team/backup/SS_/2024-03"},
+	}
+
+	for _, tt := range tests {
+		s, _ := newTestS3(t, tt.template)
+		got, err := s.(storage.DailyFolderEnsurer).EnsureDailyFolder(ctx, "team/backup", "SS_", day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("template %q: EnsureDailyFolder() = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestPutStatDelete(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestS3(t, "")
+	local := writeTemp(t, "a.png", "screenshot")
+
+	obj, err := s.Put(ctx, "team/SS_2024-03-09", "a.png", local, "image/png")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	sum := md5.Sum([]byte("screenshot"))
+	if obj.ID != "team/SS_2024-03-09/a.png" || obj.Size != 10 || obj.MD5 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Put() = %+v", obj)
+	}
+	if got := string(fake.objects[obj.ID].data); got != "screenshot" {
+		t.Fatalf("stored content = %q", got)
+	}
+
+	stat, err := s.Stat(ctx, obj.ID)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if stat.IsFolder || stat.Size != 10 || stat.MD5 != obj.MD5 || stat.ParentID != "team/SS_2024-03-09" || stat.MimeType != "image/png" {
+		t.Fatalf("Stat() = %+v", stat)
+	}
+
+	folder, err := s.Stat(ctx, "team/SS_2024-03-09")
+	if err != nil {
+		t.Fatalf("Stat() of the prefix error: %v", err)
+	}
+	if !folder.IsFolder {
+		t.Fatalf("Stat() of the prefix = %+v, want folder", folder)
+	}
+
+	if err := s.Delete(ctx, obj.ID); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := s.Stat(ctx, obj.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Stat() after delete error = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, obj.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Delete() of a missing key error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestS3(t, "")
+
+	for _, p := range []struct{ folder, name string }{
+		{"team/SS_2024-03-09", "a.png"},
+		{"team/SS_2024-03-09", "b.png"},
+		{"team/SS_2024-03-09/sub", "c.png"},
+		{"team/SS_2024-03-10", "d.png"},
+	} {
+		if _, err := s.Put(ctx, p.folder, p.name, writeTemp(t, p.name, p.name), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := s.(storage.Lister).List(ctx, "team/SS_2024-03-09")
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+
+	var got []string
+	for _, obj := range objects {
+		name := obj.ID
+		if obj.IsFolder {
+			name += "/"
+		}
+		if obj.ParentID != "team/SS_2024-03-09" {
+			t.Errorf("List() %s parent = %q", obj.ID, obj.ParentID)
+		}
+		got = append(got, name)
+	}
+	sort.Strings(got)
+
+	want := []string{"team/SS_2024-03-09/a.png", "team/SS_2024-03-09/b.png", "team/SS_2024-03-09/sub/"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	s, fake := newTestS3(t, "")
+
+	obj, err := s.Put(ctx, "team/a", "x.png", writeTemp(t, "x.png", "content"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := s.(storage.Mover).Move(ctx, obj.ID, "team/b", "y.png")
+	if err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if moved.ID != "team/b/y.png" || moved.MD5 != obj.MD5 {
+		t.Fatalf("Move() = %+v", moved)
+	}
+	if _, ok := fake.objects["team/a/x.png"]; ok {
+		t.Fatal("Move() kept the old key")
+	}
+
+	if _, err := s.(storage.Mover).Move(ctx, obj.ID, "team/c", "z.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Move() of a missing key error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,508 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	sftpclient "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP is the ssh file transfer backend. the id of every file and folder is its absolute path on the server
+type SFTP interface {
+	storage.Storage
+	Close() error
+}
+
+type Config struct {
+	// host[:port], default port 22
+	Host string
+	User string
+	// private key file, empty use the keys of the ssh agent (SSH_AUTH_SOCK)
+	KeyPath       string
+	KeyPassphrase string
+	// known_hosts file used to check the server key, default ~/.ssh/known_hosts
+	KnownHosts string
+	// remote folder where the base folders are created, must already exist
+	Root    string
+	Timeout time.Duration
+}
+
+type sftpStorage struct {
+	cfg       Config
+	sshConfig *ssh.ClientConfig
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftpclient.Client
+}
+
+func NewSFTP(cfg Config) (SFTP, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("SFTP host is empty")
+	}
+	if cfg.User == "" {
+		return nil, fmt.Errorf("SFTP user is empty")
+	}
+	if cfg.Root == "" {
+		return nil, fmt.Errorf("SFTP root is empty")
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.Host); err != nil {
+		cfg.Host = net.JoinHostPort(cfg.Host, "22")
+	}
+
+	if cfg.KnownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("Error Get Home Dir: %v", err)
+		}
+		cfg.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	cfg.Root = path.Clean("/" + strings.TrimPrefix(cfg.Root, "/"))
+
+	// never accept unknown host key, the server must be added to known_hosts first (ssh-keyscan)
+	hostKeyCallback, err := knownhosts.New(cfg.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("Error Read Known Hosts: %v", err)
+	}
+
+	auth, err := authMethod(cfg.KeyPath, cfg.KeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sftpStorage{
+		cfg: cfg,
+		sshConfig: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         cfg.Timeout,
+		},
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	info, err := s.client.Stat(cfg.Root)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("Error Check SFTP Root '%s': %v", cfg.Root, err)
+	}
+	if !info.IsDir() {
+		s.Close()
+		return nil, fmt.Errorf("SFTP root '%s' is not a directory", cfg.Root)
+	}
+
+	fmt.Println("SFTP connected successfully: ", cfg.Host)
+
+	return s, nil
+}
+
+func authMethod(keyPath, passphrase string) (ssh.AuthMethod, error) {
+	if keyPath == "" {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, fmt.Errorf("SFTP key path is empty and no ssh agent is running")
+		}
+
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("Error Connect SSH Agent: %v", err)
+		}
+
+		return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), nil
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error Read Private Key: %v", err)
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error Parse Private Key: %v", err)
+	}
+
+	return ssh.PublicKeys(signer), nil
+}
+
+func (s *sftpStorage) connect() error {
+	conn, err := ssh.Dial("tcp", s.cfg.Host, s.sshConfig)
+	if err != nil {
+		return fmt.Errorf("Error Connect SSH: %w: %v", storage.ErrUnavailable, err)
+	}
+
+	client, err := sftpclient.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("Error Start SFTP: %v", err)
+	}
+
+	s.conn = conn
+	s.client = client
+
+	return nil
+}
+
+func (s *sftpStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+
+	s.client.Close()
+	err := s.conn.Close()
+	s.client, s.conn = nil, nil
+
+	return err
+}
+
+// do run fn with the sftp client, the connection is opened again once when it was lost (laptop sleep, network change).
+// the client is safe for concurrent use, the lock only guard the connection so the transfers of the workers run together
+func (s *sftpStorage) do(ctx context.Context, fn func(c *sftpclient.Client) error) error {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		c, err := s.current()
+		if err != nil {
+			return err
+		}
+
+		err = fn(c)
+		if !isConnectionLost(err) {
+			return err
+		} else if attempt > 0 {
+			return fmt.Errorf("%w: %v", storage.ErrUnavailable, err)
+		}
+
+		fmt.Println("SFTP connection lost, reconnecting: ", err)
+		s.drop(c)
+	}
+}
+
+// current return the client, connected first when there is none
+func (s *sftpStorage) current() (*sftpclient.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.client, nil
+}
+
+// drop close the lost connection of c, unless another call already replaced it with a new one
+func (s *sftpStorage) drop(c *sftpclient.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != c {
+		return
+	}
+
+	s.client.Close()
+	s.conn.Close()
+	s.client, s.conn = nil, nil
+}
+
+func isConnectionLost(err error) bool {
+	return errors.Is(err, sftpclient.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)
+}
+
+func (s *sftpStorage) Kind() string {
+	return "sftp"
+}
+
+func (s *sftpStorage) EnsureFolder(ctx context.Context, parentID, name string) (string, error) {
+	if parentID == "" {
+		parentID = s.cfg.Root
+	}
+
+	dir, err := s.resolve(path.Join(parentID, name))
+	if err != nil {
+		return "", err
+	}
+
+	err = s.do(ctx, func(c *sftpclient.Client) error {
+		info, err := c.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("'%s' is a file", dir)
+			}
+			return nil
+		}
+
+		if err := c.Mkdir(dir); err != nil {
+			// created at the same time by other process
+			if info, statErr := c.Stat(dir); statErr == nil && info.IsDir() {
+				return nil
+			}
+			return err
+		}
+
+		fmt.Printf("Folder %s created \n", dir)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error creating folder: %w", err)
+	}
+
+	return dir, nil
+}
+
+// Put upload to a temp file next to the destination and rename it, so the file is never seen half written
+func (s *sftpStorage) Put(ctx context.Context, folderID, name, localPath, mimeType string) (*storage.Object, error) {
+	dest, err := s.resolve(path.Join(folderID, name))
+	if err != nil {
+		return nil, err
+	}
+	tmp := path.Join(path.Dir(dest), ".ss-watcher-"+path.Base(dest)+".tmp")
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error Open File: %v", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Error Stat File: %v", err)
+	}
+
+	err = s.do(ctx, func(c *sftpclient.Client) error {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		f, err := c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, storage.ContextReader(ctx, src))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			c.Remove(tmp)
+			return err
+		}
+
+		// keep the capture time on the copy, not every server allow it
+		c.Chtimes(tmp, info.ModTime(), info.ModTime())
+
+		// posix-rename replace the existing file, plain sftp rename fail when it exist
+		if err := c.PosixRename(tmp, dest); err != nil {
+			if err := replace(c, tmp, dest); err != nil {
+				c.Remove(tmp)
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Upload File: %w", err)
+	}
+
+	return &storage.Object{
+		ID:       dest,
+		Name:     name,
+		ParentID: folderID,
+		Size:     info.Size(),
+		MimeType: mimeType,
+		ModTime:  info.ModTime(),
+	}, nil
+}
+
+// replace rename tmp to dest on server without posix-rename. the existing dest is renamed to a side name first and
+// only removed once tmp took its place, it is put back when the rename fail so dest is never lost
+func replace(c *sftpclient.Client, tmp, dest string) error {
+	err := c.Rename(tmp, dest)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := c.Stat(dest); statErr != nil {
+		// nothing on dest, the rename failed for another reason
+		return err
+	}
+
+	old := path.Join(path.Dir(dest), ".ss-watcher-"+path.Base(dest)+".old")
+	// left by a previous upload stopped in between, dest is still there
+	c.Remove(old)
+	if err := c.Rename(dest, old); err != nil {
+		return err
+	}
+
+	if err := c.Rename(tmp, dest); err != nil {
+		if restoreErr := c.Rename(old, dest); restoreErr != nil {
+			return fmt.Errorf("%v, previous file kept as '%s': %v", err, old, restoreErr)
+		}
+		return err
+	}
+
+	c.Remove(old)
+	return nil
+}
+
+func (s *sftpStorage) Delete(ctx context.Context, id string) error {
+	obj, err := s.Stat(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// this function cannt delete folder
+	if obj.IsFolder {
+		return fmt.Errorf("Cannot delete folder")
+	}
+
+	err = s.do(ctx, func(c *sftpclient.Client) error {
+		return c.Remove(obj.ID)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Error Delete File '%s': %w", obj.ID, storage.ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("Error Delete File: %w", err)
+	}
+
+	fmt.Println("File deleted with path: ", obj.ID)
+
+	return nil
+}
+
+func (s *sftpStorage) Move(ctx context.Context, id, folderID, name string) (*storage.Object, error) {
+	src, err := s.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := s.resolve(path.Join(folderID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.do(ctx, func(c *sftpclient.Client) error {
+		// posix-rename replace the existing file, plain sftp rename is used on server without it
+		if err := c.PosixRename(src, dest); err != nil {
+			return c.Rename(src, dest)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Error Move File '%s': %w", src, storage.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Move File: %w", err)
+	}
+
+	return s.Stat(ctx, dest)
+}
+
+func (s *sftpStorage) Stat(ctx context.Context, id string) (*storage.Object, error) {
+	p, err := s.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var info fs.FileInfo
+	err = s.do(ctx, func(c *sftpclient.Client) error {
+		info, err = c.Stat(p)
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Error Get File '%s': %w", p, storage.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error Get File: %w", err)
+	}
+
+	return &storage.Object{
+		ID:       p,
+		Name:     path.Base(p),
+		ParentID: path.Dir(p),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		IsFolder: info.IsDir(),
+	}, nil
+}
+
+func (s *sftpStorage) List(ctx context.Context, folderID string) ([]*storage.Object, error) {
+	p, err := s.resolve(folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []fs.FileInfo
+	err = s.do(ctx, func(c *sftpclient.Client) error {
+		infos, err = c.ReadDir(p)
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Error List Folder '%s': %w", p, storage.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error List Folder: %w", err)
+	}
+
+	objects := make([]*storage.Object, 0, len(infos))
+	for _, info := range infos {
+		objects = append(objects, &storage.Object{
+			ID:       path.Join(p, info.Name()),
+			Name:     info.Name(),
+			ParentID: p,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			IsFolder: info.IsDir(),
+		})
+	}
+
+	return objects, nil
+}
+
+// Share has no meaning on sftp, access is managed by the server accounts
+func (s *sftpStorage) Share(ctx context.Context, folderID, email string) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+// resolve clean the path and make sure it is inside the root
+func (s *sftpStorage) resolve(p string) (string, error) {
+	if !path.IsAbs(p) {
+		p = path.Join(s.cfg.Root, p)
+	}
+	p = path.Clean(p)
+
+	if p != s.cfg.Root && !strings.HasPrefix(p, strings.TrimSuffix(s.cfg.Root, "/")+"/") {
+		return "", fmt.Errorf("path '%s' is outside the root '%s'", p, s.cfg.Root)
+	}
+
+	return p, nil
+}
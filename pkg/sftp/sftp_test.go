@@ -0,0 +1,416 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	sftpclient "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// renameHook wrap the commands of the in-memory server without posix-rename, like the server of old openssh or
+// of an appliance. fail make the Nth rename of a temp upload fail
+type renameHook struct {
+	sftpclient.FileCmder
+
+	mu         sync.Mutex
+	tmpRenames int
+	fail       int
+}
+
+func (h *renameHook) Filecmd(r *sftpclient.Request) error {
+	if r.Method == "Rename" && strings.HasSuffix(r.Filepath, ".tmp") {
+		h.mu.Lock()
+		h.tmpRenames++
+		fail := h.tmpRenames == h.fail
+		h.mu.Unlock()
+		if fail {
+			return errors.New("rename failed")
+		}
+	}
+
+	return h.FileCmder.Filecmd(r)
+}
+
+// testServer is an in-process ssh server with the sftp subsystem. without hook it serve the real file system
+// with pkg/sftp.NewServer, with hook the in-memory request server
+type testServer struct {
+	addr       string
+	knownHosts string
+	keyPath    string
+	hook       *renameHook
+}
+
+func newTestServer(t *testing.T, inMemory bool) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshClientPub, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &testServer{
+		knownHosts: filepath.Join(dir, "known_hosts"),
+		keyPath:    filepath.Join(dir, "id_ed25519"),
+	}
+	if err := os.WriteFile(srv.keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "alice" && bytes.Equal(key.Marshal(), sshClientPub.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	var handlers sftpclient.Handlers
+	if inMemory {
+		handlers = sftpclient.InMemHandler()
+		srv.hook = &renameHook{FileCmder: handlers.FileCmd}
+		handlers.FileCmd = srv.hook
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv.addr = ln.Addr().String()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(srv.addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(srv.knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config, inMemory, handlers)
+		}
+	}()
+
+	return srv
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig, inMemory bool, handlers sftpclient.Handlers) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				if inMemory {
+					server := sftpclient.NewRequestServer(channel, handlers)
+					server.Serve()
+					server.Close()
+				} else if server, err := sftpclient.NewServer(channel); err == nil {
+					server.Serve()
+					server.Close()
+				}
+				return
+			}
+		}(requests)
+	}
+}
+
+func (srv *testServer) open(t *testing.T, root string) SFTP {
+	t.Helper()
+
+	s, err := NewSFTP(Config{Host: srv.addr, User: "alice", KeyPath: srv.keyPath, KnownHosts: srv.knownHosts, Root: root})
+	if err != nil {
+		t.Fatalf("NewSFTP() error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func readRemote(t *testing.T, s SFTP, id string) string {
+	t.Helper()
+
+	var data []byte
+	err := s.(*sftpStorage).do(context.Background(), func(c *sftpclient.Client) error {
+		f, err := c.Open(id)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err = io.ReadAll(f)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("read '%s': %v", id, err)
+	}
+
+	return string(data)
+}
+
+func TestNewSFTPUnknownHost(t *testing.T) {
+	srv := newTestServer(t, false)
+	if err := os.WriteFile(srv.knownHosts, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewSFTP(Config{Host: srv.addr, User: "alice", KeyPath: srv.keyPath, KnownHosts: srv.knownHosts, Root: t.TempDir()}); err == nil {
+		t.Fatal("NewSFTP() with an unknown host key: want error")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s := newTestServer(t, false).open(t, root)
+
+	folder, err := storage.EnsureFolderPath(ctx, s, "", "Backup/SS_2024-03-09")
+	if err != nil {
+		t.Fatalf("EnsureFolderPath() error: %v", err)
+	}
+	if folder != path.Join(filepath.ToSlash(root), "Backup/SS_2024-03-09") {
+		t.Fatalf("EnsureFolderPath() = %q", folder)
+	}
+	if again, err := s.EnsureFolder(ctx, path.Dir(folder), "SS_2024-03-09"); err != nil || again != folder {
+		t.Fatalf("EnsureFolder() again = %q, %v", again, err)
+	}
+
+	obj, err := s.Put(ctx, folder, "a.png", writeTemp(t, "a.png", "screenshot"), "image/png")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	if obj.Size != 10 || obj.ID != path.Join(folder, "a.png") {
+		t.Fatalf("Put() = %+v", obj)
+	}
+	if got := readRemote(t, s, obj.ID); got != "screenshot" {
+		t.Fatalf("content = %q", got)
+	}
+
+	// replaced in place with posix-rename
+	if _, err := s.Put(ctx, folder, "a.png", writeTemp(t, "a.png", "edited"), "image/png"); err != nil {
+		t.Fatalf("Put() over existing error: %v", err)
+	}
+	if got := readRemote(t, s, obj.ID); got != "edited" {
+		t.Fatalf("content after replace = %q", got)
+	}
+
+	stat, err := s.Stat(ctx, obj.ID)
+	if err != nil || stat.IsFolder || stat.Size != 6 {
+		t.Fatalf("Stat() = %+v, %v", stat, err)
+	}
+
+	if _, err := s.EnsureFolder(ctx, folder, "sub"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := s.(storage.Lister).List(ctx, folder)
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "a.png,sub" {
+		t.Fatalf("List() = %v, temp file left?", names)
+	}
+
+	moved, err := s.(storage.Mover).Move(ctx, obj.ID, path.Join(folder, "sub"), "b.png")
+	if err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if moved.ID != path.Join(folder, "sub", "b.png") {
+		t.Fatalf("Move() = %+v", moved)
+	}
+	if _, err := s.Stat(ctx, obj.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Stat() after move error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Delete(ctx, moved.ID); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if err := s.Delete(ctx, moved.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Delete() of a missing file error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.Stat(ctx, "/etc/passwd"); err == nil {
+		t.Fatal("Stat() outside the root: want error")
+	}
+}
+
+func TestPutWithoutPosixRename(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t, true)
+	s := srv.open(t, "/")
+
+	folder, err := s.EnsureFolder(ctx, "", "Backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := s.Put(ctx, folder, "a.png", writeTemp(t, "a.png", "v1"), "image/png")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+
+	// plain rename refuse the existing file, the old one is swapped aside
+	if _, err := s.Put(ctx, folder, "a.png", writeTemp(t, "a.png", "v2"), "image/png"); err != nil {
+		t.Fatalf("Put() over existing error: %v", err)
+	}
+	if got := readRemote(t, s, obj.ID); got != "v2" {
+		t.Fatalf("content after replace = %q", got)
+	}
+
+	// the rename of the upload fail after the old file is swapped aside, it is put back. the posix-rename is run
+	// as a plain rename by the server and fail on the existing file like the next one, the third is after the swap
+	srv.hook.mu.Lock()
+	srv.hook.tmpRenames = 0
+	srv.hook.fail = 3
+	srv.hook.mu.Unlock()
+	if _, err := s.Put(ctx, folder, "a.png", writeTemp(t, "a.png", "v3"), "image/png"); err == nil {
+		t.Fatal("Put() with failed rename: want error")
+	}
+	if got := readRemote(t, s, obj.ID); got != "v2" {
+		t.Fatalf("content after failed replace = %q, want the previous one kept", got)
+	}
+
+	objects, err := s.(storage.Lister).List(ctx, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Name != "a.png" {
+		var names []string
+		for _, o := range objects {
+			names = append(names, o.Name)
+		}
+		t.Fatalf("List() = %v, want only a.png", names)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	s := newTestServer(t, false).open(t, t.TempDir()).(*sftpStorage)
+
+	// both calls must be inside fn at the same time, a lock held during fn would leave the second one waiting
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- s.do(context.Background(), func(c *sftpclient.Client) error {
+				started <- struct{}{}
+				<-release
+				_, err := c.Getwd()
+				return err
+			})
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("the second call waited for the first one")
+		}
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("do() error: %v", err)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, false).open(t, t.TempDir()).(*sftpStorage)
+
+	// connection dropped under the client, like after a laptop sleep
+	lost := s.client
+	s.conn.Close()
+
+	if _, err := s.Stat(ctx, "."); err != nil {
+		t.Fatalf("Stat() after the connection loss error: %v", err)
+	}
+	if s.client == nil || s.client == lost {
+		t.Fatal("connection not opened again")
+	}
+
+	// a call that saw the old connection fail does not close the new one
+	current := s.client
+	s.drop(lost)
+	if s.client != current {
+		t.Fatal("new connection dropped for the failure of the old one")
+	}
+	if _, err := s.Stat(ctx, "."); err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+}
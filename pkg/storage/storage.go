@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNotFound is returned (can be wrapped) by Stat and Delete when the object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ErrPermanent is wrapped by the error that will fail again on retry (bad request, no permission, quota),
+// so the caller does not retry it
+var ErrPermanent = errors.New("permanent failure")
+
+// ErrNotSupported is returned by the operation that the backend cannot do, ex: Share on object storage
+var ErrNotSupported = errors.New("not supported by this storage")
+
+// ErrUnavailable is wrapped by the error of the storage service (server error, rate limit after the retries, lost
+// connection), the other calls to the same storage would fail the same way for a while
+var ErrUnavailable = errors.New("storage unavailable")
+
+// IsUnavailable report whether err come from the network or the storage service and not from the file itself
+func IsUnavailable(err error) bool {
+	if errors.Is(err, ErrUnavailable) {
+		return true
+	}
+
+	var nerr net.Error
+	return errors.As(err, &nerr) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Object is a file or folder on the storage
+type Object struct {
+	// id on the backend, drive file id or the object key/path for path based backend
+	ID       string
+	Name     string
+	ParentID string
+	Size     int64
+	MimeType string
+	// hex md5 of the content, empty when the backend does not provide it
+	MD5      string
+	ModTime  time.Time
+	IsFolder bool
+	Trashed  bool
+}
+
+// Storage is the backend where the watched files are uploaded. the watcher only talk to this interface,
+// so new target only need to implement it. empty parentID mean the root of the storage
+type Storage interface {
+	// Kind is the backend type, ex: "gdrive"
+	Kind() string
+	// EnsureFolder return the id of the folder name inside parentID, created when missing
+	EnsureFolder(ctx context.Context, parentID, name string) (string, error)
+	// Put upload the local file as name inside folderID
+	Put(ctx context.Context, folderID, name, localPath, mimeType string) (*Object, error)
+	// Delete remove the file, folder cannot be deleted
+	Delete(ctx context.Context, id string) error
+	// Stat return the metadata of the file or folder
+	Stat(ctx context.Context, id string) (*Object, error)
+	// Share give email access to the folder and return the permission/share id, nothing is created if already shared
+	Share(ctx context.Context, folderID, email string) (string, error)
+}
+
+// Linker is implemented by backend that can create a link to the file for people without access to the storage
+type Linker interface {
+	ShareLink(ctx context.Context, id string) (string, error)
+}
+
+// Lister is implemented by backend that can list a folder, used to compare the storage with the records table
+type Lister interface {
+	// List return the files and folders directly inside folderID
+	List(ctx context.Context, folderID string) ([]*Object, error)
+}
+
+// Mover is implemented by backend that can rename or move a file without upload it again
+type Mover interface {
+	// Move rename the file to name inside folderID, the returned object has the id after the move
+	// (the same id on drive, the new path on path based backend)
+	Move(ctx context.Context, id, folderID, name string) (*Object, error)
+}
+
+// Updater is implemented by backend where Put with the same folder and name create another file (drive),
+// path based backend already replace the file on Put
+type Updater interface {
+	// Update upload the new content of the file id, the id, name and folder stay the same
+	Update(ctx context.Context, id, localPath, mimeType string) (*Object, error)
+}
+
+// Shortcutter is implemented by backend that can put a file on a folder without a second copy of its content,
+// used for the duplicate files. deleting the shortcut with Delete keep the file
+type Shortcutter interface {
+	// Shortcut create name inside folderID that point to the file targetID
+	Shortcut(ctx context.Context, folderID, name, targetID string) (*Object, error)
+}
+
+// DailyFolderEnsurer is implemented by backend with its own naming of the daily folder
+type DailyFolderEnsurer interface {
+	EnsureDailyFolder(ctx context.Context, baseID, prefix string, day time.Time) (string, error)
+}
+
+// UploadSession is a resumable upload started on the backend, stored so the upload continue after restart
+type UploadSession struct {
+	// backend specific key of the upload (target and local file)
+	Key string
+	URI string
+	// size and mtime of the local file when the session started, the session is dropped when the file changed
+	Size    int64
+	ModTime time.Time
+	Created time.Time
+	// folder the file is uploaded to, given when the session started
+	Folder string
+}
+
+// UploadSessionStore keep the resumable upload sessions, GetSession return nil without error when not found
+type UploadSessionStore interface {
+	GetSession(key string) (*UploadSession, error)
+	SaveSession(session *UploadSession) error
+	DeleteSession(key string) error
+}
+
+// DailyFolderName is the default daily folder name, <prefix><YYYY-MM-DD>
+func DailyFolderName(prefix string, day time.Time) string {
+	return prefix + day.Format("2006-01-02")
+}
+
+// EnsureDailyFolder return the folder of the day inside baseID
+func EnsureDailyFolder(ctx context.Context, s Storage, baseID, prefix string, day time.Time) (string, error) {
+	if d, ok := s.(DailyFolderEnsurer); ok {
+		return d.EnsureDailyFolder(ctx, baseID, prefix, day)
+	}
+
+	return s.EnsureFolder(ctx, baseID, DailyFolderName(prefix, day))
+}
+
+// EnsureFolderPath make sure every folder on the slash separated folderPath exist inside parentID
+// and return the id of the last one
+func EnsureFolderPath(ctx context.Context, s Storage, parentID, folderPath string) (string, error) {
+	id := parentID
+
+	for _, name := range strings.Split(folderPath, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+
+		var err error
+		if id, err = s.EnsureFolder(ctx, id, name); err != nil {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// ContextReader return a reader of r that stop with the error of ctx once it is canceled, so the copy of a
+// big file by the backend end with the context
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
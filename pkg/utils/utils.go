@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"fmt"
 	"math/rand"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 func RandomString(n int) string {
@@ -19,3 +22,82 @@ func IsEmailFormatValid(s string) (bool, error) {
 	emailRegex := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
 	return regexp.MatchString(emailRegex, s)
 }
+
+// SplitList split comma separated value and drop the empty items
+func SplitList(s string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Unique return the items without duplicate, keep the first order
+func Unique(items []string) []string {
+	seen := make(map[string]bool, len(items))
+	list := make([]string, 0, len(items))
+	for _, v := range items {
+		if !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+}
+
+// ParseSize parse human size like "512", "10KB" or "1.5 MB" to bytes (1 KB = 1024 bytes), empty string is 0
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit '%s'", s)
+	}
+
+	return int64(value * float64(unit)), nil
+}
+
+// FormatSize format bytes with 1024 based unit, ex: 1536 -> "1.5 KB"
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit && exp < 3; v /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
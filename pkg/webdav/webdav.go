@@ -0,0 +1,381 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+)
+
+// WebDAV is the WebDAV backend (Nextcloud, ownCloud, any RFC 4918 server). the id of every file and folder
+// is its slash separated path relative to the base url
+type WebDAV interface {
+	storage.Storage
+}
+
+type Config struct {
+	// base url of the user files, ex: https://cloud.example.com/remote.php/dav/files/alice/
+	URL string
+	// basic auth, use app password for nextcloud/owncloud
+	Username string
+	Password string
+	// timeout of each request, default 5 minutes so big recording can still be uploaded
+	Timeout time.Duration
+}
+
+type webdav struct {
+	client   *http.Client
+	base     *url.URL
+	username string
+	password string
+}
+
+func NewWebDAV(cfg Config) (WebDAV, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("WebDAV url is empty")
+	}
+
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Error Parse WebDAV url: %v", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("WebDAV url must be http or https")
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+
+	d := &webdav{
+		client:   &http.Client{Timeout: cfg.Timeout},
+		base:     base,
+		username: cfg.Username,
+		password: cfg.Password,
+	}
+
+	// check the base url and the credentials
+	if _, err := d.Stat(context.Background(), ""); err != nil {
+		return nil, fmt.Errorf("Error connecting WebDAV: %v", err)
+	}
+
+	fmt.Println("WebDAV connected successfully: ", base.Host)
+
+	return d, nil
+}
+
+func (d *webdav) Kind() string {
+	return "webdav"
+}
+
+// EnsureFolder create the collection with MKCOL, 405 mean it already exist
+func (d *webdav) EnsureFolder(ctx context.Context, parentID, name string) (string, error) {
+	id := joinPath(parentID, name)
+
+	resp, err := d.do(ctx, "MKCOL", id, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("Error creating folder: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		fmt.Printf("Folder %s created \n", id)
+		return id, nil
+	case http.StatusMethodNotAllowed:
+		return id, nil
+	default:
+		return "", fmt.Errorf("Error creating folder '%s': %s", id, resp.Status)
+	}
+}
+
+func (d *webdav) Put(ctx context.Context, folderID, name, localPath, mimeType string) (*storage.Object, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error Open File: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Error Stat File: %v", err)
+	}
+
+	id := joinPath(folderID, name)
+
+	resp, err := d.do(ctx, http.MethodPut, id, file, map[string]string{
+		"Content-Type":   mimeType,
+		"Content-Length": strconv.FormatInt(info.Size(), 10),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Upload File: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error Upload File '%s': %s", id, resp.Status)
+	}
+
+	return &storage.Object{
+		ID:       id,
+		Name:     name,
+		ParentID: folderID,
+		Size:     info.Size(),
+		MimeType: mimeType,
+		ModTime:  info.ModTime(),
+	}, nil
+}
+
+// Delete remove the file, DELETE on collection is recursive so folder is refused like the drive backend
+func (d *webdav) Delete(ctx context.Context, id string) error {
+	obj, err := d.Stat(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// this function cannt delete folder
+	if obj.IsFolder {
+		return fmt.Errorf("Cannot delete folder")
+	}
+
+	resp, err := d.do(ctx, http.MethodDelete, id, nil, nil)
+	if err != nil {
+		return fmt.Errorf("Error Delete File: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusAccepted:
+		fmt.Println("File deleted with path: ", id)
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("Error Delete File '%s': %w", id, storage.ErrNotFound)
+	default:
+		return fmt.Errorf("Error Delete File '%s': %s", id, resp.Status)
+	}
+}
+
+// Move rename the file with MOVE, the destination is replaced like Put do
+func (d *webdav) Move(ctx context.Context, id, folderID, name string) (*storage.Object, error) {
+	// the missing source is 403 on some servers (x/net/webdav) instead of 404, so it is checked first
+	if _, err := d.Stat(ctx, id); err != nil {
+		return nil, fmt.Errorf("Error Move File: %w", err)
+	}
+
+	dest := joinPath(folderID, name)
+
+	resp, err := d.do(ctx, "MOVE", id, nil, map[string]string{
+		"Destination": d.urlFor(dest),
+		"Overwrite":   "T",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error Move File: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent, http.StatusOK:
+		return d.Stat(ctx, dest)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("Error Move File '%s': %w", id, storage.ErrNotFound)
+	default:
+		return nil, fmt.Errorf("Error Move File '%s': %s", id, resp.Status)
+	}
+}
+
+// Stat read the properties with PROPFIND depth 0
+func (d *webdav) Stat(ctx context.Context, id string) (*storage.Object, error) {
+	ms, err := d.propfind(ctx, id, "0")
+	if err != nil {
+		return nil, fmt.Errorf("Error Get File: %w", err)
+	}
+
+	if len(ms.Responses) == 0 {
+		return nil, fmt.Errorf("Error Get File '%s': empty PROPFIND response", id)
+	}
+
+	return toObject(id, &ms.Responses[0]), nil
+}
+
+// List read the folder with PROPFIND depth 1, the first response is the folder itself
+func (d *webdav) List(ctx context.Context, folderID string) ([]*storage.Object, error) {
+	ms, err := d.propfind(ctx, folderID, "1")
+	if err != nil {
+		return nil, fmt.Errorf("Error List Folder: %w", err)
+	}
+
+	folderID = strings.Trim(folderID, "/")
+
+	var objects []*storage.Object
+	for i := range ms.Responses {
+		id, err := d.idFor(ms.Responses[i].Href)
+		if err != nil {
+			return nil, fmt.Errorf("Error List Folder: %v", err)
+		}
+		if id == folderID {
+			continue
+		}
+
+		objects = append(objects, toObject(id, &ms.Responses[i]))
+	}
+
+	return objects, nil
+}
+
+func (d *webdav) propfind(ctx context.Context, id, depth string) (*multistatus, error) {
+	body := strings.NewReader(`<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propfind xmlns:d="DAV:"><d:prop>` +
+		`<d:resourcetype/><d:getcontentlength/><d:getcontenttype/><d:getlastmodified/>` +
+		`</d:prop></d:propfind>`)
+
+	resp, err := d.do(ctx, "PROPFIND", id, body, map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("'%s': %w", id, storage.ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("'%s': %s", id, resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("Error Parse PROPFIND: %v", err)
+	}
+
+	return &ms, nil
+}
+
+// idFor turn the href of PROPFIND response (path or full url) into the id relative to the base url
+func (d *webdav) idFor(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(u.Path, d.base.Path) {
+		return "", fmt.Errorf("href '%s' is outside '%s'", href, d.base.Path)
+	}
+
+	return strings.Trim(strings.TrimPrefix(u.Path, d.base.Path), "/"), nil
+}
+
+func toObject(id string, r *response) *storage.Object {
+	obj := &storage.Object{
+		ID:       id,
+		Name:     path.Base(id),
+		ParentID: path.Dir(id),
+	}
+
+	// one response can have several propstat, only the 200 one has the values
+	for _, ps := range r.Propstats {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+
+		obj.IsFolder = ps.Prop.ResourceType.Collection != nil
+		obj.Size, _ = strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64)
+		obj.MimeType = ps.Prop.ContentType
+		if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+			obj.ModTime = t
+		}
+	}
+
+	return obj
+}
+
+// Share is not part of WebDAV, every server has its own share api
+func (d *webdav) Share(ctx context.Context, folderID, email string) (string, error) {
+	return "", storage.ErrNotSupported
+}
+
+func (d *webdav) do(ctx context.Context, method, id string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.urlFor(id), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if length, ok := headers["Content-Length"]; ok {
+		req.ContentLength, _ = strconv.ParseInt(length, 10, 64)
+	}
+
+	if d.username != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s, check username and password", method, id, resp.Status)
+	}
+
+	// server down or overloaded, not the fault of the file
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %w: %s", method, id, storage.ErrUnavailable, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// urlFor escape every segment of the id and resolve it on the base url
+func (d *webdav) urlFor(id string) string {
+	id = strings.Trim(id, "/")
+	segments := strings.Split(id, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	u := *d.base
+	u.Path = d.base.Path + id
+	u.RawPath = d.base.EscapedPath() + strings.Join(segments, "/")
+
+	return u.String()
+}
+
+func joinPath(parts ...string) string {
+	return strings.Trim(path.Join(parts...), "/")
+}
+
+type multistatus struct {
+	Responses []response `xml:"response"`
+}
+
+type response struct {
+	Href      string `xml:"href"`
+	Propstats []struct {
+		Status string `xml:"status"`
+		Prop   struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+			ContentLength string `xml:"getcontentlength"`
+			ContentType   string `xml:"getcontenttype"`
+			LastModified  string `xml:"getlastmodified"`
+		} `xml:"prop"`
+	} `xml:"propstat"`
+}
@@ -0,0 +1,271 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/momokii/ss-watcher/pkg/storage"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+// newTestWebDAV start a golang.org/x/net/webdav server on a temp folder under /dav/files/alice/ with basic auth
+func newTestWebDAV(t *testing.T) (WebDAV, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	handler := &xwebdav.Handler{
+		Prefix:     "/dav/files/alice",
+		FileSystem: xwebdav.Dir(root),
+		LockSystem: xwebdav.NewMemLS(),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	d, err := NewWebDAV(Config{URL: srv.URL + "/dav/files/alice", Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewWebDAV() error: %v", err)
+	}
+
+	return d, root
+}
+
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewWebDAVBadCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	if _, err := NewWebDAV(Config{URL: srv.URL, Username: "alice", Password: "wrong"}); err == nil {
+		t.Fatal("NewWebDAV() with bad credentials: want error")
+	}
+}
+
+func TestEnsureFolder(t *testing.T) {
+	ctx := context.Background()
+	d, root := newTestWebDAV(t)
+
+	base, err := d.EnsureFolder(ctx, "", "Backup")
+	if err != nil {
+		t.Fatalf("EnsureFolder() error: %v", err)
+	}
+	// MKCOL on an existing collection is 405, the folder is reused
+	if again, err := d.EnsureFolder(ctx, "", "Backup"); err != nil || again != base {
+		t.Fatalf("EnsureFolder() again = %q, %v", again, err)
+	}
+
+	id, err := storage.EnsureFolderPath(ctx, d, base, "SS_2024-03-09/my shots")
+	if err != nil {
+		t.Fatalf("EnsureFolderPath() error: %v", err)
+	}
+	if id != "Backup/SS_2024-03-09/my shots" {
+		t.Fatalf("EnsureFolderPath() = %q", id)
+	}
+	if info, err := os.Stat(filepath.Join(root, "Backup", "SS_2024-03-09", "my shots")); err != nil || !info.IsDir() {
+		t.Fatalf("folder not created on the server: %v", err)
+	}
+}
+
+func TestPutStat(t *testing.T) {
+	ctx := context.Background()
+	d, root := newTestWebDAV(t)
+
+	folder, err := d.EnsureFolder(ctx, "", "Backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := d.Put(ctx, folder, "shot #1.png", writeTemp(t, "a.png", "screenshot"), "image/png")
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	if obj.ID != "Backup/shot #1.png" || obj.Size != 10 {
+		t.Fatalf("Put() = %+v", obj)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "Backup", "shot #1.png")); err != nil || string(data) != "screenshot" {
+		t.Fatalf("content on the server = %q, %v", data, err)
+	}
+
+	stat, err := d.Stat(ctx, obj.ID)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if stat.IsFolder || stat.Size != 10 || stat.ParentID != "Backup" || stat.Name != "shot #1.png" || stat.ModTime.IsZero() {
+		t.Fatalf("Stat() = %+v", stat)
+	}
+
+	dir, err := d.Stat(ctx, folder)
+	if err != nil {
+		t.Fatalf("Stat() of the folder error: %v", err)
+	}
+	if !dir.IsFolder {
+		t.Fatalf("Stat() of the folder = %+v, want folder", dir)
+	}
+
+	if _, err := d.Stat(ctx, "Backup/missing.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Stat() of a missing file error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	d, _ := newTestWebDAV(t)
+
+	folder, err := storage.EnsureFolderPath(ctx, d, "", "Backup/SS_2024-03-09")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.EnsureFolder(ctx, folder, "sub"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "b c.png"} {
+		if _, err := d.Put(ctx, folder, name, writeTemp(t, "f", name), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := d.(storage.Lister).List(ctx, folder)
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+
+	var got []string
+	for _, obj := range objects {
+		name := obj.ID
+		if obj.IsFolder {
+			name += "/"
+		}
+		got = append(got, name)
+	}
+	sort.Strings(got)
+
+	want := []string{"Backup/SS_2024-03-09/a.png", "Backup/SS_2024-03-09/b c.png", "Backup/SS_2024-03-09/sub/"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+
+	if _, err := d.(storage.Lister).List(ctx, "Backup/missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("List() of a missing folder error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	d, root := newTestWebDAV(t)
+
+	folder, err := d.EnsureFolder(ctx, "", "Backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := d.Put(ctx, folder, "a.png", writeTemp(t, "a.png", "x"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// collection is refused, DELETE on it is recursive
+	if err := d.Delete(ctx, folder); err == nil {
+		t.Fatal("Delete() of a folder: want error")
+	}
+
+	if err := d.Delete(ctx, obj.ID); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "Backup", "a.png")); !os.IsNotExist(err) {
+		t.Fatalf("file still on the server: %v", err)
+	}
+	if err := d.Delete(ctx, obj.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Delete() of a missing file error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	d, root := newTestWebDAV(t)
+
+	from, err := d.EnsureFolder(ctx, "", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := d.EnsureFolder(ctx, "", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := d.Put(ctx, from, "x.png", writeTemp(t, "x.png", "content"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mover, ok := d.(storage.Mover)
+	if !ok {
+		t.Fatal("WebDAV does not implement storage.Mover")
+	}
+
+	moved, err := mover.Move(ctx, obj.ID, to, "y z.png")
+	if err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if moved.ID != "b/y z.png" || moved.Size != 7 {
+		t.Fatalf("Move() = %+v", moved)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "x.png")); !os.IsNotExist(err) {
+		t.Fatalf("old file still on the server: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "b", "y z.png")); err != nil || string(data) != "content" {
+		t.Fatalf("moved content = %q, %v", data, err)
+	}
+
+	if _, err := mover.Move(ctx, obj.ID, to, "other.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Move() of a missing file error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPutUnavailable(t *testing.T) {
+	ctx := context.Background()
+	handler := &xwebdav.Handler{FileSystem: xwebdav.Dir(t.TempDir()), LockSystem: xwebdav.NewMemLS()}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	d, err := NewWebDAV(Config{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// server down is not the fault of the file, the watcher hold the other jobs of the target
+	if _, err := d.Put(ctx, "", "a.png", writeTemp(t, "a.png", "x"), "image/png"); !storage.IsUnavailable(err) {
+		t.Fatalf("Put() error = %v, want unavailable", err)
+	}
+
+	srv.Close()
+	if _, err := d.Stat(ctx, "a.png"); !storage.IsUnavailable(err) {
+		t.Fatalf("Stat() on a stopped server error = %v, want unavailable", err)
+	}
+}